@@ -0,0 +1,176 @@
+// Package allowlist decides which messages to accept, by the address they came from and the hub and device they name.
+package allowlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+
+	"tempest_exporter/relay"
+)
+
+// Reasons for rejecting a message
+const (
+	Address = "address"
+	Hub     = "hub"
+	Device  = "device"
+	Invalid = "invalid"
+)
+
+// Reasons lists every reason for rejecting a message.
+var Reasons = []string{Address, Hub, Device, Invalid}
+
+// Rules includes and excludes by address or serial number. If Include is not empty, only matching messages are
+// accepted, and any matching Exclude are rejected even if they are included.
+type Rules struct {
+	Include []string
+	Exclude []string
+}
+
+// Options holds the rules for each way of identifying a message. Addresses are IP addresses or CIDR prefixes, and
+// serial numbers are glob patterns such as "HB-0003*".
+type Options struct {
+	Addresses Rules
+	Hubs      Rules
+	Devices   Rules
+}
+
+// List checks messages against the rules. A nil List accepts everything.
+type List struct {
+	include, exclude []netip.Prefix
+	hubs, devices    Rules
+}
+
+// New returns a List for the rules, or nil if there are none.
+func New(opts Options) (*List, error) {
+	if opts.Addresses.empty() && opts.Hubs.empty() && opts.Devices.empty() {
+		return nil, nil
+	}
+
+	l := &List{hubs: opts.Hubs, devices: opts.Devices}
+	for _, s := range opts.Addresses.Include {
+		prefix, err := ParseAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		l.include = append(l.include, prefix)
+	}
+	for _, s := range opts.Addresses.Exclude {
+		prefix, err := ParseAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		l.exclude = append(l.exclude, prefix)
+	}
+	for _, patterns := range [][]string{opts.Hubs.Include, opts.Hubs.Exclude, opts.Devices.Include, opts.Devices.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	return l, nil
+}
+
+// ParseAddress parses an IP address, which matches only itself, or a CIDR prefix.
+func ParseAddress(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Check returns why the packet should be rejected, or "" to accept it.
+//
+// Address rules apply only to packets which came from an address, and not, for example, to those fetched from the
+// WeatherFlow API. Hub rules apply to the hub which relayed a device's message, or to the hub itself for its status,
+// and device rules to every other message. Messages which don't name a hub or device are rejected by any include rules
+// for them.
+func (l *List) Check(p relay.Packet) string {
+	if l == nil {
+		return ""
+	}
+
+	if addr := p.Addr(); addr != nil && !l.AllowsAddr(addr.IP) {
+		return Address
+	}
+
+	if l.hubs.empty() && l.devices.empty() {
+		return ""
+	}
+	var msg struct {
+		SerialNumber string `json:"serial_number"`
+		Type         string `json:"type"`
+		HubSn        string `json:"hub_sn"`
+	}
+	if err := json.Unmarshal(p.Message, &msg); err != nil {
+		return Invalid
+	}
+	hub, device := msg.HubSn, msg.SerialNumber
+	if msg.Type == "hub_status" {
+		hub, device = msg.SerialNumber, ""
+	}
+	if !l.hubs.allows(hub) {
+		return Hub
+	}
+	if device != "" && !l.devices.allows(device) {
+		return Device
+	}
+	return ""
+}
+
+// AllowsAddr returns whether the address rules allow ip.
+func (l *List) AllowsAddr(ip net.IP) bool {
+	if l == nil {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return (len(l.include) == 0 || contains(l.include, addr)) && !contains(l.exclude, addr)
+}
+
+func (r Rules) empty() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0
+}
+
+func (r Rules) allows(serial string) bool {
+	if len(r.Include) > 0 && !matchAny(r.Include, serial) {
+		return false
+	}
+	return !matchAny(r.Exclude, serial)
+}
+
+func matchAny(patterns []string, serial string) bool {
+	if serial == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, serial); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
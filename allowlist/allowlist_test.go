@@ -0,0 +1,73 @@
+package allowlist
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+const (
+	wind      = `{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}`
+	otherWind = `{"serial_number":"ST-00020001","type":"rapid_wind","hub_sn":"HB-00040000","ob":[1688668572,0.85,113]}`
+	hubStatus = `{"serial_number":"HB-00031344","type":"hub_status","timestamp":1688668572,"seq":1}`
+	noHub     = `{"serial_number":"ST-00019709","type":"rapid_wind","ob":[1688668572,0.85,113]}`
+)
+
+func packet(msg string, ip string) relay.Packet {
+	p := relay.Packet{Time: time.Now(), Source: "websocket", Message: []byte(msg)}
+	if ip != "" {
+		p = relay.NewPacket([]byte(msg), &net.UDPAddr{IP: net.ParseIP(ip), Port: 50222})
+	}
+	return p
+}
+
+func TestList_Check(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		msg  string
+		ip   string
+		want string
+	}{
+		{"no rules", Options{}, wind, "10.0.0.1", ""},
+		{"address included", Options{Addresses: Rules{Include: []string{"192.168.1.0/24"}}}, wind, "192.168.1.50", ""},
+		{"address not included", Options{Addresses: Rules{Include: []string{"192.168.1.0/24"}}}, wind, "192.168.2.50", Address},
+		{"single address", Options{Addresses: Rules{Include: []string{"192.168.1.50"}}}, wind, "192.168.1.51", Address},
+		{"address excluded", Options{Addresses: Rules{Include: []string{"192.168.1.0/24"}, Exclude: []string{"192.168.1.66"}}}, wind, "192.168.1.66", Address},
+		{"IPv6", Options{Addresses: Rules{Include: []string{"fd00::/8"}}}, wind, "fd12::1", ""},
+		{"no address", Options{Addresses: Rules{Include: []string{"192.168.1.0/24"}}}, wind, "", ""},
+		{"hub included", Options{Hubs: Rules{Include: []string{"HB-0003*"}}}, wind, "10.0.0.1", ""},
+		{"hub not included", Options{Hubs: Rules{Include: []string{"HB-0003*"}}}, otherWind, "10.0.0.1", Hub},
+		{"hub excluded", Options{Hubs: Rules{Exclude: []string{"HB-00040000"}}}, otherWind, "10.0.0.1", Hub},
+		{"hub status", Options{Hubs: Rules{Include: []string{"HB-00031344"}}, Devices: Rules{Include: []string{"ST-00019709"}}}, hubStatus, "10.0.0.1", ""},
+		{"no hub", Options{Hubs: Rules{Include: []string{"HB-0003*"}}}, noHub, "10.0.0.1", Hub},
+		{"device included", Options{Devices: Rules{Include: []string{"ST-00019709"}}}, wind, "10.0.0.1", ""},
+		{"device excluded", Options{Devices: Rules{Exclude: []string{"ST-00019709"}}}, wind, "10.0.0.1", Device},
+		{"invalid", Options{Devices: Rules{Include: []string{"ST-00019709"}}}, `{"serial_number":`, "10.0.0.1", Invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := New(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Check(packet(tt.msg, tt.ip)); got != tt.want {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Addresses: Rules{Include: []string{"192.168.1.0/33"}}},
+		{Addresses: Rules{Exclude: []string{"gateway"}}},
+		{Hubs: Rules{Include: []string{"HB-["}}},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) succeeded", opts)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"tempest_exporter/backfill"
+	"tempest_exporter/config"
+	"tempest_exporter/remote"
+	"tempest_exporter/tempestapi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errTokenRequired = errors.New("a WeatherFlow personal access token is required, via TOKEN, TOKEN_FILE, or token in the configuration file")
+
+func backfillCommand(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("backfill", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	if cfg.Token == "" {
+		return configError{errTokenRequired}
+	}
+
+	client := tempestapi.NewClient(cfg.Token)
+	stations, err := client.ListStations(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing stations: %w", err)
+	}
+
+	if len(stations) == 0 {
+		return errors.New("no stations found")
+	}
+
+	log.Printf("found stations:")
+	var startAt time.Time
+	for _, station := range stations {
+		log.Printf("  - %s (station #%d)", station.Name, station.StationID)
+		if startAt.IsZero() || startAt.Before(station.CreatedAt) {
+			startAt = station.CreatedAt
+		}
+	}
+
+	opts := backfill.Options{
+		Concurrency:      cfg.Backfill.Concurrency,
+		Rate:             cfg.Backfill.Rate,
+		ProgressInterval: 10 * time.Second,
+	}
+	log.Printf("fetching with concurrency %d at up to %g requests/s", opts.Concurrency, opts.Rate)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Calibrated and derived like the live readings, so that the two agree
+	fetch := func(ctx context.Context, station tempestapi.Station, startAt time.Time, endAt time.Time) ([]prometheus.Metric, error) {
+		return client.GetObservationsWithOptions(ctx, station, startAt, endAt, cfg.ReportOptions)
+	}
+	results := backfill.Fetch(ctx, fetch, backfill.Windows(stations, startAt, time.Now()), opts)
+
+	w, err := backfillOutput(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("error configuring output: %w", err)
+	}
+	for r := range results {
+		if r.Err != nil {
+			w.Abort()
+			return fmt.Errorf("error fetching %#v for %d-%d: %w", r.Station, r.StartAt.Unix(), r.EndAt.Unix(), r.Err)
+		}
+		if err := w.Write(r.Metrics); err != nil {
+			w.Abort()
+			return fmt.Errorf("error writing metrics: %w", err)
+		}
+	}
+	if ctx.Err() != nil {
+		w.Abort()
+		return fmt.Errorf("backfill interrupted: %w", ctx.Err())
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error closing output: %w", err)
+	}
+	return nil
+}
+
+func backfillOutput(ctx context.Context, cfg *config.Config) (backfill.Output, error) {
+	b := cfg.Backfill
+	if c := cfg.Sinks.RemoteWrite; c.URL != "" {
+		log.Printf("sending to %q using remote write", c.URL)
+		return backfill.NewPusher(ctx, remote.NewWriteClient(remoteOptions(c)), b.BatchSize), nil
+	}
+	if url := cfg.Sinks.Pushgateway.URL; url != "" {
+		log.Printf("sending to %q", url)
+		return backfill.NewPusher(ctx, remote.NewImportClient(remote.Options{URL: url}), b.BatchSize), nil
+	}
+
+	return backfill.NewOutput(backfill.WriterOptions{
+		Format:   backfill.Format(b.Format),
+		Dir:      b.Dir,
+		Pattern:  b.Filename,
+		MaxBytes: b.MaxBytes,
+		MaxSpan:  b.MaxSpan,
+
+		BlockDuration: b.BlockDuration,
+	})
+}
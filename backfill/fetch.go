@@ -0,0 +1,196 @@
+package backfill
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"tempest_exporter/tempestapi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FetchFunc retrieves the observations for one station over one window.
+type FetchFunc func(ctx context.Context, station tempestapi.Station, startAt time.Time, endAt time.Time) ([]prometheus.Metric, error)
+
+type Options struct {
+	// The maximum number of requests in flight at once
+	Concurrency int
+
+	// The maximum number of requests started per second across all workers, or 0 for no limit
+	Rate float64
+
+	// How often to log progress, or 0 to never log
+	ProgressInterval time.Duration
+}
+
+// Window is a single day of observations for a single station.
+type Window struct {
+	Station tempestapi.Station
+	StartAt time.Time
+	EndAt   time.Time
+}
+
+type Result struct {
+	Window
+	Metrics []prometheus.Metric
+	Err     error
+}
+
+// Windows lists every window between startAt and endAt, ordered by day and then by station.
+func Windows(stations []tempestapi.Station, startAt time.Time, endAt time.Time) []Window {
+	var out []Window
+	for cur := startAt; cur.Before(endAt); cur = cur.AddDate(0, 0, 1) {
+		next := cur.AddDate(0, 0, 1) // for 1-minute observation frequency
+		for _, station := range stations {
+			out = append(out, Window{
+				Station: station,
+				StartAt: cur,
+				EndAt:   next,
+			})
+		}
+	}
+	return out
+}
+
+// Fetch retrieves every window using a pool of workers, returning results in the same order as windows regardless of
+// the order in which the requests complete.
+//
+// The returned channel is closed after the last result, after the first error, or when ctx is done. Callers must
+// either drain the channel or cancel ctx.
+func Fetch(ctx context.Context, fetch FetchFunc, windows []Window, opts Options) <-chan Result {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Each window gets its own single-use channel, which are handed to the reorderer in window order
+	type job struct {
+		window Window
+		result chan Result
+	}
+	jobs := make(chan job)
+	pending := make(chan chan Result, concurrency)
+
+	var limit <-chan time.Time
+	stopLimit := func() {}
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		limit = ticker.C
+		stopLimit = ticker.Stop
+	}
+
+	// Dispatch jobs in order
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for _, w := range windows {
+			j := job{window: w, result: make(chan Result, 1)}
+			select {
+			case pending <- j.result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Run the workers
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if limit != nil {
+					select {
+					case <-limit:
+					case <-ctx.Done():
+						j.result <- Result{Window: j.window, Err: ctx.Err()}
+						continue
+					}
+				}
+				metrics, err := fetch(ctx, j.window.Station, j.window.StartAt, j.window.EndAt)
+				j.result <- Result{Window: j.window, Metrics: metrics, Err: err}
+			}
+		}()
+	}
+
+	// Reassemble the results in order
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		defer stopLimit()
+		defer wg.Wait()
+		defer cancel()
+
+		p := newProgress(len(windows), opts.ProgressInterval)
+		for ch := range pending {
+			var r Result
+			select {
+			case r = <-ch:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+			if r.Err != nil {
+				return
+			}
+			p.done(r.Window)
+		}
+		p.finish()
+	}()
+
+	return out
+}
+
+type progress struct {
+	total     int
+	completed int
+	interval  time.Duration
+	startedAt time.Time
+	loggedAt  time.Time
+}
+
+func newProgress(total int, interval time.Duration) *progress {
+	now := time.Now()
+	return &progress{
+		total:     total,
+		interval:  interval,
+		startedAt: now,
+		loggedAt:  now,
+	}
+}
+
+func (p *progress) done(w Window) {
+	p.completed++
+	if p.interval <= 0 || time.Since(p.loggedAt) < p.interval {
+		return
+	}
+	p.loggedAt = time.Now()
+
+	elapsed := p.loggedAt.Sub(p.startedAt)
+	eta := time.Duration(float64(elapsed) / float64(p.completed) * float64(p.total-p.completed))
+	log.Printf("fetched %d/%d windows (%.1f%%) through %s, ETA %s",
+		p.completed, p.total, 100*float64(p.completed)/float64(p.total),
+		w.StartAt.Format(time.RFC3339), eta.Round(time.Second))
+}
+
+func (p *progress) finish() {
+	if p.interval <= 0 {
+		return
+	}
+	log.Printf("fetched %d windows in %s", p.completed, time.Since(p.startedAt).Round(time.Second))
+}
@@ -0,0 +1,96 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempestapi"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWindows(t *testing.T) {
+	stations := []tempestapi.Station{{StationID: 1}, {StationID: 2}}
+	start := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	got := Windows(stations, start, start.Add(49*time.Hour))
+	if len(got) != 6 {
+		t.Fatalf("len(Windows()) = %d, want 6", len(got))
+	}
+	for i, w := range got {
+		wantStart := start.AddDate(0, 0, i/2)
+		if !w.StartAt.Equal(wantStart) || !w.EndAt.Equal(wantStart.AddDate(0, 0, 1)) {
+			t.Errorf("window %d = %s-%s, want %s-%s", i, w.StartAt, w.EndAt, wantStart, wantStart.AddDate(0, 0, 1))
+		}
+		if w.Station.StationID != i%2+1 {
+			t.Errorf("window %d station = %d, want %d", i, w.Station.StationID, i%2+1)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	stations := []tempestapi.Station{{StationID: 1}, {StationID: 2}, {StationID: 3}}
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	windows := Windows(stations, start, start.AddDate(0, 0, 30))
+
+	var inFlight, maxInFlight int32
+	fetch := func(ctx context.Context, station tempestapi.Station, startAt time.Time, endAt time.Time) ([]prometheus.Metric, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+		return nil, nil
+	}
+
+	var i int
+	for r := range Fetch(context.Background(), fetch, windows, Options{Concurrency: 5}) {
+		if r.Err != nil {
+			t.Fatalf("unexpected error: %v", r.Err)
+		}
+		if !reflect.DeepEqual(r.Window, windows[i]) {
+			t.Errorf("result %d = %v, want %v", i, r.Window, windows[i])
+		}
+		i++
+	}
+	if i != len(windows) {
+		t.Errorf("got %d results, want %d", i, len(windows))
+	}
+	if maxInFlight > 5 {
+		t.Errorf("max in flight = %d, want <= 5", maxInFlight)
+	}
+}
+
+func TestFetch_error(t *testing.T) {
+	stations := []tempestapi.Station{{StationID: 1}}
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	windows := Windows(stations, start, start.AddDate(0, 0, 100))
+
+	failure := errors.New("failure")
+	fetch := func(ctx context.Context, station tempestapi.Station, startAt time.Time, endAt time.Time) ([]prometheus.Metric, error) {
+		if startAt.Equal(windows[10].StartAt) {
+			return nil, failure
+		}
+		return nil, nil
+	}
+
+	var results []Result
+	for r := range Fetch(context.Background(), fetch, windows, Options{Concurrency: 4, Rate: 1000}) {
+		results = append(results, r)
+	}
+	if len(results) != 11 {
+		t.Fatalf("got %d results, want 11", len(results))
+	}
+	if !errors.Is(results[10].Err, failure) {
+		t.Errorf("last error = %v, want %v", results[10].Err, failure)
+	}
+}
@@ -0,0 +1,114 @@
+package backfill
+
+import (
+	"bufio"
+	"sort"
+
+	"tempest_exporter/tempest"
+)
+
+// openMetricsEncoder collects samples until the file is complete, since OpenMetrics requires every sample in a family to
+// be written together and every sample in a series to be written in timestamp order.
+type openMetricsEncoder struct {
+	series map[string]*omSeries
+	size   int64
+	buf    []byte
+}
+
+type omSeries struct {
+	key    string
+	sample tempest.Sample
+	points []omPoint
+}
+
+type omPoint struct {
+	timestampMs int64
+	value       float64
+}
+
+func newOpenMetricsEncoder() *openMetricsEncoder {
+	return &openMetricsEncoder{series: make(map[string]*omSeries)}
+}
+
+func (e *openMetricsEncoder) add(w *bufio.Writer, s tempest.Sample) error {
+	e.buf = s.AppendOpenMetrics(e.buf[:0])
+	e.size += int64(len(e.buf))
+
+	e.buf = s.AppendSeries(e.buf[:0])
+	key := string(e.buf)
+
+	series := e.series[key]
+	if series == nil {
+		series = &omSeries{key: key, sample: s}
+		e.series[key] = series
+	}
+	series.points = append(series.points, omPoint{s.TimestampMs, s.Value})
+	return nil
+}
+
+func (e *openMetricsEncoder) buffered() int64 {
+	return e.size
+}
+
+func (e *openMetricsEncoder) finish(w *bufio.Writer) error {
+	order := make(map[*tempest.Family]int, len(tempest.All))
+	for i, desc := range tempest.All {
+		order[tempest.Lookup(desc)] = i
+	}
+
+	byFamily := make(map[*tempest.Family][]*omSeries)
+	var families []*tempest.Family
+	for _, series := range e.series {
+		family := series.sample.Family
+		if byFamily[family] == nil {
+			families = append(families, family)
+		}
+		byFamily[family] = append(byFamily[family], series)
+	}
+
+	// Write families in the order of tempest.All
+	sort.Slice(families, func(i, j int) bool {
+		return order[families[i]] < order[families[j]]
+	})
+
+	for _, family := range families {
+		e.buf = family.AppendOpenMetricsHeader(e.buf[:0])
+		if _, err := w.Write(e.buf); err != nil {
+			return err
+		}
+
+		series := byFamily[family]
+		sort.Slice(series, func(i, j int) bool {
+			return series[i].key < series[j].key
+		})
+		for _, s := range series {
+			if err := s.write(w, &e.buf); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := w.WriteString("# EOF\n")
+	return err
+}
+
+func (s *omSeries) write(w *bufio.Writer, buf *[]byte) error {
+	sort.SliceStable(s.points, func(i, j int) bool {
+		return s.points[i].timestampMs < s.points[j].timestampMs
+	})
+
+	sample := s.sample
+	for i, p := range s.points {
+		// Timestamps must strictly increase, so if a sample was written more than once, keep the last one
+		if i+1 < len(s.points) && s.points[i+1].timestampMs == p.timestampMs {
+			continue
+		}
+
+		sample.TimestampMs, sample.Value = p.timestampMs, p.value
+		*buf = sample.AppendOpenMetrics((*buf)[:0])
+		if _, err := w.Write(*buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
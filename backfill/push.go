@@ -0,0 +1,77 @@
+package backfill
+
+import (
+	"context"
+	"log"
+
+	"tempest_exporter/remote"
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pusher sends samples to a remote client in batches of a bounded size.
+//
+// Batches are cut from the samples in the order they were fetched, so an interrupted backfill sends the same batches
+// again when it is run again, and the remote store ends up with the same data points.
+type Pusher struct {
+	ctx       context.Context
+	client    remote.Client
+	batchSize int
+	batch     []tempest.Sample
+	sent      int
+}
+
+func NewPusher(ctx context.Context, client remote.Client, batchSize int) *Pusher {
+	if batchSize <= 0 {
+		batchSize = 10_000
+	}
+	return &Pusher{
+		ctx:       ctx,
+		client:    client,
+		batchSize: batchSize,
+		batch:     make([]tempest.Sample, 0, batchSize),
+	}
+}
+
+func (p *Pusher) Write(metrics []prometheus.Metric) error {
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			return err
+		}
+		p.batch = append(p.batch, s)
+		if len(p.batch) >= p.batchSize {
+			if err := p.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pusher) flush() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	if err := p.client.Send(p.ctx, p.batch); err != nil {
+		return err
+	}
+	p.sent += len(p.batch)
+	p.batch = p.batch[:0]
+	return nil
+}
+
+// Close sends any remaining samples.
+func (p *Pusher) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	log.Printf("sent %d samples", p.sent)
+	return nil
+}
+
+// Abort discards any samples which have not been sent.
+func (p *Pusher) Abort() {
+	p.batch = p.batch[:0]
+}
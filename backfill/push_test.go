@@ -0,0 +1,53 @@
+package backfill
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeClient struct {
+	batches [][]tempest.Sample
+}
+
+func (c *fakeClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	c.batches = append(c.batches, append([]tempest.Sample(nil), samples...))
+	return nil
+}
+
+func TestPusher(t *testing.T) {
+	c := &fakeClient{}
+	p := NewPusher(context.Background(), c, 4)
+
+	start := time.Unix(1688666400, 0)
+	for i := 0; i < 10; i++ {
+		if err := p.Write([]prometheus.Metric{testMetric(start.Add(time.Duration(i)*time.Minute), float64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.batches) != 2 {
+		t.Errorf("sent %d batches before closing, want 2", len(c.batches))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	var n int
+	for _, batch := range c.batches {
+		sizes = append(sizes, len(batch))
+		for _, s := range batch {
+			if s.Value != float64(n) {
+				t.Errorf("sample %d = %v, want %v", n, s.Value, n)
+			}
+			n++
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Errorf("batch sizes = %v, want [4 4 2]", sizes)
+	}
+}
@@ -0,0 +1,171 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// BlockWriter writes samples directly into Prometheus TSDB blocks, one block per aligned time range, which can be moved
+// into a Prometheus data directory.
+//
+// Blocks are written once samples have moved a full range past them, so samples must arrive roughly in time order.
+// Samples which arrive after their block was written end up in a second, overlapping block, which Prometheus merges
+// during compaction.
+type BlockWriter struct {
+	dir      string
+	rangeMs  int64
+	blocks   map[int64]*openBlock
+	latestMs int64
+	skipped  int
+}
+
+type openBlock struct {
+	w   *tsdb.BlockWriter
+	app storage.Appender
+}
+
+func NewBlockWriter(dir string, blockDuration time.Duration) (*BlockWriter, error) {
+	if blockDuration <= 0 {
+		blockDuration = 24 * time.Hour
+	}
+	if blockDuration%time.Hour != 0 {
+		return nil, fmt.Errorf("block duration must be a whole number of hours: %s", blockDuration)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &BlockWriter{
+		dir:     dir,
+		rangeMs: blockDuration.Milliseconds(),
+		blocks:  make(map[int64]*openBlock),
+	}, nil
+}
+
+// Write appends metrics to the blocks covering their timestamps.
+func (w *BlockWriter) Write(metrics []prometheus.Metric) error {
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteSample(s); err != nil {
+			return err
+		}
+	}
+	return w.commit()
+}
+
+// WriteSample appends a single sample. It is not visible until the next Write or Close.
+func (w *BlockWriter) WriteSample(s tempest.Sample) error {
+	start := s.TimestampMs - s.TimestampMs%w.rangeMs
+	b := w.blocks[start]
+	if b == nil {
+		// The head refuses samples more than half its chunk range behind the newest sample, so give it twice the range
+		// to accept anything within the block regardless of order, as promtool does
+		bw, err := tsdb.NewBlockWriter(kitlog.NewNopLogger(), w.dir, 2*w.rangeMs)
+		if err != nil {
+			return err
+		}
+		b = &openBlock{w: bw}
+		w.blocks[start] = b
+	}
+	if b.app == nil {
+		b.app = b.w.Appender(context.Background())
+	}
+
+	builder := labels.NewScratchBuilder(len(s.Labels) + 1)
+	builder.Add(labels.MetricName, s.Family.Name)
+	for _, label := range s.Labels {
+		builder.Add(label.Name, label.Value)
+	}
+	builder.Sort()
+
+	if _, err := b.app.Append(0, builder.Labels(), s.TimestampMs, s.Value); err != nil {
+		if errors.Is(err, storage.ErrOutOfOrderSample) || errors.Is(err, storage.ErrDuplicateSampleForTimestamp) {
+			w.skipped++
+			return nil
+		}
+		return err
+	}
+
+	if s.TimestampMs > w.latestMs {
+		w.latestMs = s.TimestampMs
+	}
+	return nil
+}
+
+func (w *BlockWriter) commit() error {
+	for _, b := range w.blocks {
+		if b.app == nil {
+			continue
+		}
+		if err := b.app.Commit(); err != nil {
+			return err
+		}
+		b.app = nil
+	}
+
+	// Write out any block which ended a full range before the latest sample
+	return w.flush(w.latestMs - w.latestMs%w.rangeMs - w.rangeMs)
+}
+
+// flush writes every block starting before the given time.
+func (w *BlockWriter) flush(beforeMs int64) error {
+	var starts []int64
+	for start := range w.blocks {
+		if start < beforeMs {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	for _, start := range starts {
+		b := w.blocks[start]
+		delete(w.blocks, start)
+
+		id, err := b.w.Flush(context.Background())
+		if closeErr := b.w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("error writing block for %s: %w", time.UnixMilli(start).UTC().Format(time.RFC3339), err)
+		}
+		log.Printf("wrote block %s for %s", id, time.UnixMilli(start).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Close writes every remaining block.
+func (w *BlockWriter) Close() error {
+	if err := w.commit(); err != nil {
+		return err
+	}
+	if w.skipped > 0 {
+		log.Printf("skipped %d out of order or conflicting samples", w.skipped)
+	}
+	return w.flush(w.latestMs + 1)
+}
+
+// Abort discards every block which has not yet been written.
+func (w *BlockWriter) Abort() {
+	for start, b := range w.blocks {
+		if b.app != nil {
+			_ = b.app.Rollback()
+		}
+		_ = b.w.Close()
+		delete(w.blocks, start)
+	}
+}
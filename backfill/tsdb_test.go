@@ -0,0 +1,107 @@
+package backfill
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+func TestBlockWriter(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := NewBlockWriter(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write three days, one day at a time for each of two stations, as a backfill would
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day < 3; day++ {
+		for _, instance := range []string{"ST-00000001", "ST-00000002"} {
+			var metrics []prometheus.Metric
+			for minute := 0; minute < 24*60; minute++ {
+				ts := start.AddDate(0, 0, day).Add(time.Duration(minute) * time.Minute)
+				metrics = append(metrics, prometheus.NewMetricWithTimestamp(ts,
+					prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, float64(minute), instance, "air")))
+			}
+			if err := w.Write(metrics); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Querying expects a WAL, as would be found in a real data directory
+	if err := os.Mkdir(filepath.Join(dir, "wal"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := tsdb.OpenDBReadOnly(dir, kitlog.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	blocks, err := db.Blocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(blocks))
+	}
+	for i, b := range blocks {
+		meta := b.Meta()
+		wantMin := start.AddDate(0, 0, i).UnixMilli()
+		if meta.MinTime != wantMin || meta.MaxTime > wantMin+24*time.Hour.Milliseconds() {
+			t.Errorf("block %d covers %d-%d, want within %d-%d", i, meta.MinTime, meta.MaxTime, wantMin, wantMin+24*time.Hour.Milliseconds())
+		}
+		if meta.Stats.NumSeries != 2 || meta.Stats.NumSamples != 2*24*60 {
+			t.Errorf("block %d has %d series and %d samples, want 2 and %d", i, meta.Stats.NumSeries, meta.Stats.NumSamples, 2*24*60)
+		}
+	}
+
+	q, err := db.Querier(context.Background(), math.MinInt64, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ss := q.Select(true, nil,
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "tempest_temperature_c"),
+		labels.MustNewMatcher(labels.MatchEqual, "instance", "ST-00000002"),
+		labels.MustNewMatcher(labels.MatchEqual, "kind", "air"),
+	)
+	var n int
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+			ts, v := it.At()
+			if want := float64((ts - start.UnixMilli()) / time.Minute.Milliseconds() % (24 * 60)); v != want {
+				t.Errorf("value at %d = %v, want %v", ts, v, want)
+			}
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3*24*60 {
+		t.Errorf("got %d samples, want %d", n, 3*24*60)
+	}
+}
@@ -0,0 +1,292 @@
+package backfill
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Format string
+
+const (
+	// Prometheus text exposition format, one line per sample, as accepted by VictoriaMetrics and other import APIs
+	FormatText Format = "text"
+
+	// OpenMetrics text format, as accepted by `promtool tsdb create-blocks-from openmetrics`
+	FormatOpenMetrics Format = "openmetrics"
+
+	// Prometheus TSDB blocks, as found in a Prometheus data directory
+	FormatTSDB Format = "tsdb"
+)
+
+// Output receives the metrics fetched by a backfill.
+type Output interface {
+	Write(metrics []prometheus.Metric) error
+
+	// Close finishes writing the output.
+	Close() error
+
+	// Abort discards anything not yet completely written.
+	Abort()
+}
+
+// NewOutput returns a BlockWriter for FormatTSDB, or a Writer for other formats.
+func NewOutput(opts WriterOptions) (Output, error) {
+	if opts.Format == FormatTSDB {
+		dir := opts.Dir
+		if dir == "" {
+			dir = "data"
+		}
+		return NewBlockWriter(dir, opts.BlockDuration)
+	}
+	return NewWriter(opts)
+}
+
+type WriterOptions struct {
+	// The format of each file, defaulting to FormatText
+	Format Format
+
+	// The directory in which to write files, defaulting to the current directory, or to "data" for FormatTSDB
+	Dir string
+
+	// The name of each file, as a format string given the file's sequence number starting at 1. Files are gzipped if
+	// the pattern ends with ".gz".
+	Pattern string
+
+	// Start a new file once this many bytes have been written, or 0 for no limit. Compressed text files are measured
+	// after compression, which happens in blocks, so files may run over by a small amount. OpenMetrics files must be
+	// sorted before anything can be written, so they are measured before compression.
+	MaxBytes int64
+
+	// Start a new file rather than writing a sample this far after the first sample in the current file, or 0 for no
+	// limit
+	MaxSpan time.Duration
+
+	// The time range covered by each block for FormatTSDB, defaulting to 24 hours
+	BlockDuration time.Duration
+}
+
+// Writer streams samples into a series of files, starting a new file whenever the current one grows too large.
+//
+// Each file is written under a temporary name and renamed into place once it is complete, so a file with the final name
+// is never partially written.
+type Writer struct {
+	opts WriterOptions
+	n    int
+	cur  *outputFile
+}
+
+func NewWriter(opts WriterOptions) (*Writer, error) {
+	if opts.Format == "" {
+		opts.Format = FormatText
+	}
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	switch {
+	case opts.Pattern != "":
+	case opts.Format == FormatText:
+		opts.Pattern = "tempest_%03d.txt.gz"
+	case opts.Format == FormatOpenMetrics:
+		opts.Pattern = "tempest_%03d.om"
+	}
+
+	if _, err := opts.Format.newEncoder(); err != nil {
+		return nil, err
+	}
+	return &Writer{opts: opts}, nil
+}
+
+// Write appends metrics to the output.
+func (w *Writer) Write(metrics []prometheus.Metric) error {
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteSample(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSample appends a single sample to the output.
+func (w *Writer) WriteSample(s tempest.Sample) error {
+	if w.cur != nil && w.full(s) {
+		if err := w.cur.close(); err != nil {
+			return err
+		}
+		w.cur = nil
+	}
+
+	if w.cur == nil {
+		w.n++
+		f, err := createOutputFile(filepath.Join(w.opts.Dir, fmt.Sprintf(w.opts.Pattern, w.n)), w.opts.Format)
+		if err != nil {
+			return err
+		}
+		w.cur = f
+		w.cur.firstMs = s.TimestampMs
+	}
+
+	return w.cur.enc.add(w.cur.w, s)
+}
+
+func (w *Writer) full(s tempest.Sample) bool {
+	if w.opts.MaxBytes > 0 && w.cur.size() >= w.opts.MaxBytes {
+		return true
+	}
+	if w.opts.MaxSpan > 0 && time.Duration(s.TimestampMs-w.cur.firstMs)*time.Millisecond >= w.opts.MaxSpan {
+		return true
+	}
+	return false
+}
+
+// Close finishes the current file, if any.
+func (w *Writer) Close() error {
+	if w.cur == nil {
+		return nil
+	}
+	err := w.cur.close()
+	w.cur = nil
+	return err
+}
+
+// Abort discards the current file, if any.
+func (w *Writer) Abort() {
+	if w.cur != nil {
+		w.cur.abort()
+		w.cur = nil
+	}
+}
+
+// encoder writes samples in a particular format, either immediately or once the file is complete.
+type encoder interface {
+	add(w *bufio.Writer, s tempest.Sample) error
+	finish(w *bufio.Writer) error
+
+	// buffered returns the number of bytes which will be written by finish
+	buffered() int64
+}
+
+func (f Format) newEncoder() (encoder, error) {
+	switch f {
+	case FormatText:
+		return &textEncoder{}, nil
+	case FormatOpenMetrics:
+		return newOpenMetricsEncoder(), nil
+	default:
+		return nil, fmt.Errorf("unknown format: %q", string(f))
+	}
+}
+
+type textEncoder struct {
+	buf []byte
+}
+
+func (e *textEncoder) add(w *bufio.Writer, s tempest.Sample) error {
+	e.buf = s.AppendText(e.buf[:0])
+	_, err := w.Write(e.buf)
+	return err
+}
+
+func (e *textEncoder) finish(w *bufio.Writer) error {
+	return nil
+}
+
+func (e *textEncoder) buffered() int64 {
+	return 0
+}
+
+type outputFile struct {
+	name    string
+	file    *countingFile
+	gzip    *gzip.Writer
+	w       *bufio.Writer
+	enc     encoder
+	firstMs int64
+}
+
+func createOutputFile(name string, format Format) (*outputFile, error) {
+	enc, err := format.newEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("writing %s", name)
+
+	out := &outputFile{
+		name: name,
+		file: &countingFile{File: f},
+		enc:  enc,
+	}
+	var w io.Writer = out.file
+	if strings.HasSuffix(name, ".gz") {
+		out.gzip = gzip.NewWriter(w)
+		w = out.gzip
+	}
+	out.w = bufio.NewWriter(w)
+	return out, nil
+}
+
+func (f *outputFile) size() int64 {
+	return f.file.n + f.enc.buffered()
+}
+
+func (f *outputFile) close() error {
+	err := f.enc.finish(f.w)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	if err == nil && f.gzip != nil {
+		err = f.gzip.Close()
+	}
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if err == nil {
+		err = f.file.Chmod(0644)
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.file.Name(), f.name)
+	}
+	if err != nil {
+		_ = os.Remove(f.file.Name())
+		return fmt.Errorf("error writing %s: %w", f.name, err)
+	}
+	return nil
+}
+
+func (f *outputFile) abort() {
+	_ = f.file.Close()
+	_ = os.Remove(f.file.Name())
+}
+
+type countingFile struct {
+	*os.File
+	n int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.n += int64(n)
+	return n, err
+}
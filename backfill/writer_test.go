@@ -0,0 +1,183 @@
+package backfill
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testMetric(t time.Time, value float64) prometheus.Metric {
+	return prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, value, "ST-00000001", "air"))
+}
+
+func newTestWriter(t *testing.T, opts WriterOptions) *Writer {
+	t.Helper()
+	w, err := NewWriter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gzr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriter_span(t *testing.T) {
+	dir := t.TempDir()
+
+	// A stale file from a previous, longer run must be replaced rather than partly overwritten
+	if err := os.WriteFile(filepath.Join(dir, "out_1.txt"), []byte(strings.Repeat("stale\n", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newTestWriter(t, WriterOptions{Dir: dir, Pattern: "out_%d.txt", MaxSpan: time.Hour})
+	start := time.Unix(1688666400, 0)
+	for i := 0; i < 4; i++ {
+		if err := w.Write([]prometheus.Metric{testMetric(start.Add(time.Duration(i)*40*time.Minute), float64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := listDir(t, dir); len(names) != 2 || !strings.HasPrefix(names[0], ".out_2.txt.") {
+		t.Errorf("files while writing = %v, want a temporary file for out_2.txt", names)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"out_1.txt", "out_2.txt"}) {
+		t.Errorf("files = %v", names)
+	}
+	want1 := "tempest_temperature_c{instance=\"ST-00000001\",kind=\"air\"} 0 1688666400000\n" +
+		"tempest_temperature_c{instance=\"ST-00000001\",kind=\"air\"} 1 1688668800000\n"
+	if got := readFile(t, filepath.Join(dir, "out_1.txt")); got != want1 {
+		t.Errorf("out_1.txt:\n%s\nwant:\n%s", got, want1)
+	}
+	want2 := "tempest_temperature_c{instance=\"ST-00000001\",kind=\"air\"} 2 1688671200000\n" +
+		"tempest_temperature_c{instance=\"ST-00000001\",kind=\"air\"} 3 1688673600000\n"
+	if got := readFile(t, filepath.Join(dir, "out_2.txt")); got != want2 {
+		t.Errorf("out_2.txt:\n%s\nwant:\n%s", got, want2)
+	}
+}
+
+func TestWriter_bytes(t *testing.T) {
+	dir := t.TempDir()
+
+	w := newTestWriter(t, WriterOptions{Dir: dir, MaxBytes: 1000})
+	start := time.Unix(1688666400, 0)
+	for i := 0; i < 100_000; i++ {
+		if err := w.Write([]prometheus.Metric{testMetric(start.Add(time.Duration(i)*time.Minute), float64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listDir(t, dir)
+	if len(names) < 2 {
+		t.Fatalf("files = %v, want several", names)
+	}
+	if names[0] != "tempest_001.txt.gz" || names[1] != "tempest_002.txt.gz" {
+		t.Errorf("files = %v, want tempest_001.txt.gz, tempest_002.txt.gz, ...", names)
+	}
+	var lines int
+	for _, name := range names {
+		lines += strings.Count(readFile(t, filepath.Join(dir, name)), "\n")
+	}
+	if lines != 100_000 {
+		t.Errorf("lines = %d, want 100000", lines)
+	}
+}
+
+func TestWriter_abort(t *testing.T) {
+	dir := t.TempDir()
+
+	w := newTestWriter(t, WriterOptions{Dir: dir})
+	if err := w.Write([]prometheus.Metric{testMetric(time.Unix(1688666400, 0), 1)}); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+
+	if names := listDir(t, dir); len(names) != 0 {
+		t.Errorf("files = %v, want none", names)
+	}
+}
+
+func TestWriter_openMetrics(t *testing.T) {
+	dir := t.TempDir()
+
+	w := newTestWriter(t, WriterOptions{Dir: dir, Format: FormatOpenMetrics})
+	start := time.Unix(1688666400, 0)
+	for _, ts := range []time.Time{start.Add(time.Minute), start, start.Add(time.Minute)} {
+		if err := w.Write([]prometheus.Metric{
+			prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, 50, "ST-00000002")),
+			prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, 60, "ST-00000001")),
+			prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Uptime, prometheus.CounterValue, float64(ts.Unix()-start.Unix()), "HB-00000001")),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# TYPE tempest_uptime_seconds counter
+# UNIT tempest_uptime_seconds seconds
+# HELP tempest_uptime_seconds The uptime of the device
+tempest_uptime_seconds_total{instance="HB-00000001"} 0 1688666400
+tempest_uptime_seconds_total{instance="HB-00000001"} 60 1688666460
+# TYPE tempest_humidity_percent gauge
+# UNIT tempest_humidity_percent percent
+# HELP tempest_humidity_percent A relative humidity measurement
+tempest_humidity_percent{instance="ST-00000001"} 60 1688666400
+tempest_humidity_percent{instance="ST-00000001"} 60 1688666460
+tempest_humidity_percent{instance="ST-00000002"} 50 1688666400
+tempest_humidity_percent{instance="ST-00000002"} 50 1688666460
+# EOF
+`
+	if got := readFile(t, filepath.Join(dir, "tempest_001.om")); got != want {
+		t.Errorf("tempest_001.om:\n%s\nwant:\n%s", got, want)
+	}
+}
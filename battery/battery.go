@@ -0,0 +1,198 @@
+// Package battery follows the health of each device's battery: the power save mode it has likely entered, whether it
+// is charging, and how long until it runs low.
+package battery
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The voltages below which a Tempest enters each power save mode as its battery drains, and above which it returns to
+// each mode as it charges. Mode 0 is full performance, while in mode 3 the device reports only every five minutes.
+var (
+	falling = [3]float64{2.455, 2.41, 2.375}
+	rising  = [3]float64{2.455, 2.415, 2.39}
+)
+
+// Critical is the voltage at which a Tempest enters its last power save mode.
+const Critical = 2.375
+
+// How long a report interval means the device is in mode 3
+const slowReports = 5 * time.Minute
+
+// How much history to fit the trend to, and how much is needed to fit it at all
+const (
+	window    = 3 * time.Hour
+	minWindow = 10 * time.Minute
+)
+
+// Changes slower than this, in volts per second, are steady: 1 mV per hour
+const steady = 0.001 / 3600
+
+// Tracker remembers recent battery readings from each device.
+type Tracker struct {
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+type device struct {
+	mode     int
+	readings []reading
+}
+
+type reading struct {
+	at    time.Time
+	volts float64
+}
+
+func New() *Tracker {
+	return &Tracker{devices: make(map[string]*device)}
+}
+
+// Observe returns metrics with the power save mode, trend, and time until critical for each battery reading among
+// them, logging when a device changes mode.
+func (t *Tracker) Observe(metrics []prometheus.Metric) []prometheus.Metric {
+	type observation struct {
+		instance    string
+		timestampMs int64
+	}
+	volts := make(map[observation]float64)
+	intervals := make(map[observation]time.Duration)
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			continue
+		}
+		o := observation{s.Label("instance"), s.TimestampMs}
+		switch s.Family.Desc {
+		case tempest.Battery:
+			volts[o] = s.Value
+		case tempest.ReportInterval:
+			intervals[o] = time.Duration(s.Value * float64(time.Second))
+		}
+	}
+	if len(volts) == 0 {
+		return metrics
+	}
+
+	observations := make([]observation, 0, len(volts))
+	for o := range volts {
+		observations = append(observations, o)
+	}
+	sort.Slice(observations, func(i, j int) bool {
+		if observations[i].instance != observations[j].instance {
+			return observations[i].instance < observations[j].instance
+		}
+		return observations[i].timestampMs < observations[j].timestampMs
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := metrics[:len(metrics):len(metrics)]
+	for _, o := range observations {
+		at := time.UnixMilli(o.timestampMs)
+		out = append(out, t.observe(o.instance, at, volts[o], intervals[o])...)
+	}
+	return out
+}
+
+func (t *Tracker) observe(instance string, at time.Time, volts float64, interval time.Duration) []prometheus.Metric {
+	// Readings replayed or arriving out of order say nothing about the device now, so they get only the mode they
+	// imply on their own
+	d, ok := t.devices[instance]
+	if ok && len(d.readings) > 0 && !at.After(d.readings[len(d.readings)-1].at) {
+		m := prometheus.MustNewConstMetric(tempest.PowerSaveMode, prometheus.GaugeValue, float64(Mode(volts, interval, 0)), instance)
+		return []prometheus.Metric{prometheus.NewMetricWithTimestamp(at, m)}
+	}
+
+	mode := Mode(volts, interval, 0)
+	if !ok {
+		d = &device{mode: mode}
+		t.devices[instance] = d
+	} else {
+		mode = Mode(volts, interval, d.mode)
+		if mode != d.mode {
+			log.Printf("%s changed from power save mode %d to %d at %.3f V", instance, d.mode, mode, volts)
+			d.mode = mode
+		}
+	}
+
+	d.readings = append(d.readings, reading{at, volts})
+	var n int
+	for n < len(d.readings) && at.Sub(d.readings[n].at) > window {
+		n++
+	}
+	d.readings = d.readings[n:]
+
+	out := []prometheus.Metric{
+		prometheus.MustNewConstMetric(tempest.PowerSaveMode, prometheus.GaugeValue, float64(mode), instance),
+	}
+	if slope, ok := fit(d.readings); ok {
+		trend := 0.0
+		if slope > steady {
+			trend = 1
+		} else if slope < -steady {
+			trend = -1
+			remaining := 0.0
+			if volts > Critical {
+				remaining = (volts - Critical) / -slope
+			}
+			out = append(out, prometheus.MustNewConstMetric(tempest.BatteryTimeToCritical, prometheus.GaugeValue, remaining, instance))
+		}
+		out = append(out, prometheus.MustNewConstMetric(tempest.BatteryTrend, prometheus.GaugeValue, trend, instance))
+	}
+
+	for i, m := range out {
+		out[i] = prometheus.NewMetricWithTimestamp(at, m)
+	}
+	return out
+}
+
+// Mode infers the power save mode from the battery voltage and report interval, given the mode the device was in
+// before. A device recovers to a better mode only once its voltage has risen a little past where it left that mode.
+func Mode(volts float64, interval time.Duration, previous int) int {
+	mode := 3
+	for i, threshold := range falling {
+		if i < previous {
+			threshold = rising[i]
+		}
+		if volts >= threshold {
+			mode = i
+			break
+		}
+	}
+
+	// Only in mode 3 does the device slow its reports to every five minutes, which is more reliable than the voltage
+	switch {
+	case interval >= slowReports:
+		return 3
+	case interval > 0 && mode == 3:
+		return 2
+	}
+	return mode
+}
+
+// fit returns the least squares slope of the readings in volts per second, if they span long enough to tell.
+func fit(readings []reading) (float64, bool) {
+	if len(readings) < 2 || readings[len(readings)-1].at.Sub(readings[0].at) < minWindow {
+		return 0, false
+	}
+
+	start := readings[0].at
+	var sumX, sumY, sumXX, sumXY float64
+	for _, r := range readings {
+		x := r.at.Sub(start).Seconds()
+		sumX += x
+		sumY += r.volts
+		sumXX += x * x
+		sumXY += x * r.volts
+	}
+	n := float64(len(readings))
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX), true
+}
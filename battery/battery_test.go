@@ -0,0 +1,144 @@
+package battery
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMode(t *testing.T) {
+	tests := []struct {
+		volts    float64
+		interval time.Duration
+		previous int
+		want     int
+	}{
+		{2.6, time.Minute, 0, 0},
+		{2.43, time.Minute, 0, 1},
+		{2.39, time.Minute, 1, 2},
+		{2.36, time.Minute, 2, 2},
+		{2.36, 5 * time.Minute, 2, 3},
+		{2.36, 0, 2, 3},
+		{2.42, 5 * time.Minute, 3, 3},
+
+		// Recovering takes a little more than leaving
+		{2.412, time.Minute, 2, 2},
+		{2.416, time.Minute, 2, 1},
+		{2.412, time.Minute, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := Mode(tt.volts, tt.interval, tt.previous); got != tt.want {
+			t.Errorf("Mode(%v, %v, %d) = %d, want %d", tt.volts, tt.interval, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestTracker_Observe(t *testing.T) {
+	tr := New()
+	start := time.Unix(1688668741, 0)
+
+	// Discharging at 10 mV per hour, reporting every minute, from 2.46 V
+	var got map[string]float64
+	for i := 0; i <= 120; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		volts := 2.46 - 0.01*float64(i)/60
+		metrics := []prometheus.Metric{
+			prometheus.NewMetricWithTimestamp(at, prometheus.MustNewConstMetric(tempest.Battery, prometheus.GaugeValue, volts, "ST-00019709")),
+			prometheus.NewMetricWithTimestamp(at, prometheus.MustNewConstMetric(tempest.ReportInterval, prometheus.GaugeValue, 60, "ST-00019709")),
+		}
+		out := tr.Observe(metrics)
+
+		got = make(map[string]float64)
+		for _, m := range out {
+			s, err := tempest.NewSample(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.TimestampMs != at.UnixMilli() {
+				t.Errorf("%s stamped %d, want %d", s.Family.Name, s.TimestampMs, at.UnixMilli())
+			}
+			got[s.Family.Name] = s.Value
+		}
+		if i == 0 {
+			if _, ok := got["tempest_battery_trend"]; ok {
+				t.Error("trend reported from a single reading")
+			}
+		}
+	}
+
+	// 2.44 V after two hours, 65 mV above critical
+	if got["tempest_power_save_mode"] != 1 {
+		t.Errorf("mode = %v, want 1", got["tempest_power_save_mode"])
+	}
+	if got["tempest_battery_trend"] != -1 {
+		t.Errorf("trend = %v, want -1", got["tempest_battery_trend"])
+	}
+	if want := 6.5 * 3600; math.Abs(got["tempest_battery_time_to_critical_seconds"]-want) > 60 {
+		t.Errorf("time to critical = %v, want %v", got["tempest_battery_time_to_critical_seconds"], want)
+	}
+}
+
+func TestTracker_charging(t *testing.T) {
+	tr := New()
+	start := time.Unix(1688668741, 0)
+	var out []prometheus.Metric
+	for i := 0; i <= 30; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		out = tr.Observe([]prometheus.Metric{
+			prometheus.NewMetricWithTimestamp(at, prometheus.MustNewConstMetric(tempest.Battery, prometheus.GaugeValue, 2.5+0.001*float64(i), "ST-00019709")),
+		})
+	}
+	for _, m := range out {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch s.Family.Desc {
+		case tempest.BatteryTrend:
+			if s.Value != 1 {
+				t.Errorf("trend = %v, want 1", s.Value)
+			}
+		case tempest.BatteryTimeToCritical:
+			t.Errorf("time to critical reported while charging")
+		}
+	}
+}
+
+func TestTracker_outOfOrder(t *testing.T) {
+	tr := New()
+	start := time.Unix(1688668741, 0)
+	observe := func(at time.Time, volts float64) map[string]float64 {
+		got := make(map[string]float64)
+		for _, m := range tr.Observe([]prometheus.Metric{
+			prometheus.NewMetricWithTimestamp(at, prometheus.MustNewConstMetric(tempest.Battery, prometheus.GaugeValue, volts, "ST-00019709")),
+		}) {
+			s, err := tempest.NewSample(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[s.Family.Name] = s.Value
+		}
+		return got
+	}
+	for i := 0; i <= 30; i++ {
+		observe(start.Add(time.Duration(i)*time.Minute), 2.45-0.001*float64(i))
+	}
+
+	// A late reading from when the battery was low only gets the mode it implies itself
+	got := observe(start.Add(-time.Hour), 2.38)
+	want := map[string]float64{"tempest_battery_volts": 2.38, "tempest_power_save_mode": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// And leaves the device's mode and trend as they were
+	got = observe(start.Add(31*time.Minute), 2.419)
+	if got["tempest_power_save_mode"] != 1 || got["tempest_battery_trend"] != -1 {
+		t.Errorf("got %v, want mode 1 and trend -1", got)
+	}
+}
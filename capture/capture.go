@@ -0,0 +1,171 @@
+// Package capture records received messages to newline-delimited JSON files, which can later be replayed.
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+// The time of rotation in rotated file names, which sorts in time order
+const rotatedLayout = "20060102T150405.000000000Z"
+
+// Options configures a Writer.
+type Options struct {
+	// The file currently being written
+	Path string
+
+	// Rotate once the file reaches this many bytes, or never if zero
+	MaxBytes int64
+
+	// Rotate once the file has been written for this long, or never if zero
+	MaxAge time.Duration
+
+	// How many rotated files to keep, or zero to keep them all
+	Keep int
+}
+
+// Writer appends each packet to a file as a line of JSON, with the time and address it was received from. Once the
+// file grows too large or too old, it is renamed with the time of rotation, like capture-20230706T201855.123456789Z.ndjson,
+// and a new one started.
+type Writer struct {
+	opts Options
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func NewWriter(opts Options) (*Writer, error) {
+	w := &Writer{opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// Write records a packet.
+func (w *Writer) Write(p relay.Packet) error {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var rotateErr error
+	if w.due(int64(len(line))) {
+		rotateErr = w.rotate()
+	}
+	// Carry on writing to the path whatever went wrong with rotation, rather than stopping the capture
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return errors.Join(rotateErr, err)
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+// due returns whether the file should be rotated before writing n more bytes. A file is never rotated while empty.
+func (w *Writer) due(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	return (w.opts.MaxBytes > 0 && w.size+n > w.opts.MaxBytes) ||
+		(w.opts.MaxAge > 0 && time.Since(w.opened) >= w.opts.MaxAge)
+}
+
+// rotate closes the file and renames it, leaving the next write to open a new one.
+func (w *Writer) rotate() error {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(w.opts.Path)
+	base := strings.TrimSuffix(w.opts.Path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format(rotatedLayout), ext)
+	if err := os.Rename(w.opts.Path, rotated); err != nil {
+		return err
+	}
+	return w.prune(base, ext)
+}
+
+// prune removes the oldest rotated files beyond those to keep.
+func (w *Writer) prune(base string, ext string) error {
+	if w.opts.Keep <= 0 {
+		return nil
+	}
+	rotated, err := filepath.Glob(escapeGlob(base) + "-" + layoutGlob(rotatedLayout) + escapeGlob(ext))
+	if err != nil {
+		return err
+	}
+	// The timestamps sort in time order
+	sort.Strings(rotated)
+	for len(rotated) > w.opts.Keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// layoutGlob returns a pattern matching any time formatted with a numeric layout.
+func layoutGlob(layout string) string {
+	var b strings.Builder
+	for _, r := range layout {
+		if r >= '0' && r <= '9' {
+			b.WriteString("[0-9]")
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	return w.f.Close()
+}
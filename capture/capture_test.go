@@ -0,0 +1,110 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tempest_exporter/relay"
+)
+
+const message = `{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}`
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.ndjson")
+	source := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 50), Port: 50222}
+
+	// Not rotated files, so never pruned
+	for _, name := range []string{"capture-old.ndjson", "capture-20230706T201855Z.ndjson"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Room for two packets per file
+	p := relay.NewPacket([]byte(message), source)
+	line, _ := json.Marshal(p)
+	w, err := NewWriter(Options{Path: path, MaxBytes: int64(2*len(line) + 2), Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		if err := w.Write(relay.NewPacket([]byte(message), source)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Four rotations, of which two are kept, plus the current file
+	rotated, _ := filepath.Glob(filepath.Join(dir, "capture-*.*Z.ndjson"))
+	if len(rotated) != 2 {
+		t.Errorf("kept %d rotated files, want 2: %v", len(rotated), rotated)
+	}
+	for _, name := range []string{"capture-old.ndjson", "capture-20230706T201855Z.ndjson"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	var n int
+	for s.Scan() {
+		n++
+		var got relay.Packet
+		if err := json.Unmarshal(s.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Message) != message || got.Source != "192.168.1.50:50222" || got.Time.IsZero() {
+			t.Errorf("got %+v", got)
+		}
+	}
+	if n != 1 {
+		t.Errorf("current file has %d packets, want 1", n)
+	}
+}
+
+func TestWriter_pruneFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.ndjson")
+
+	// The oldest rotated file, which can't be removed
+	stuck := filepath.Join(dir, "capture-20000101T000000.000000000Z.ndjson")
+	if err := os.MkdirAll(filepath.Join(stuck, "in-use"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(Options{Path: path, MaxBytes: 1, Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	p := relay.NewPacket([]byte(message), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 50), Port: 50222})
+	if err := w.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	// Rotation fails, but the capture carries on in a new file
+	for i := 0; i < 2; i++ {
+		if err := w.Write(p); err == nil {
+			t.Error("pruning didn't fail")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(b), "\n"); n != 1 {
+			t.Errorf("current file has %d packets, want 1", n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package clock compares the timestamps devices give their readings with when the readings were received, to catch
+// clocks which have drifted or been reset.
+package clock
+
+import (
+	"math"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// What to do with readings whose timestamps are too far from when they were received
+const (
+	// Stamp them with when they were received instead
+	Restamp = "restamp"
+
+	// Leave them out
+	Drop = "drop"
+)
+
+// Options sets how much skew to tolerate, and what to do beyond that.
+type Options struct {
+	// The largest difference allowed between a reading's timestamp and when it was received, or zero for no limit
+	MaxSkew time.Duration
+
+	Action string
+}
+
+// Check returns metrics with tempest_clock_skew_seconds for each device among them, handling any readings whose
+// timestamps are too far from received according to opts. Metrics without timestamps are left alone.
+func Check(metrics []prometheus.Metric, received time.Time, opts Options) []prometheus.Metric {
+	// Devices in the order they first appear, and the latest timestamp from each
+	var devices []string
+	latest := make(map[string]int64)
+
+	out := make([]prometheus.Metric, 0, len(metrics)+1)
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil || s.TimestampMs == 0 {
+			out = append(out, m)
+			continue
+		}
+		instance := s.Label("instance")
+		if ts, ok := latest[instance]; !ok {
+			devices = append(devices, instance)
+			latest[instance] = s.TimestampMs
+		} else if s.TimestampMs > ts {
+			latest[instance] = s.TimestampMs
+		}
+
+		skew := received.Sub(time.UnixMilli(s.TimestampMs))
+		if opts.MaxSkew > 0 && time.Duration(math.Abs(float64(skew))) > opts.MaxSkew {
+			switch opts.Action {
+			case Drop:
+				continue
+			case Restamp:
+				m = prometheus.NewMetricWithTimestamp(received, m)
+			}
+		}
+		out = append(out, m)
+	}
+
+	for _, instance := range devices {
+		skew := received.Sub(time.UnixMilli(latest[instance])).Seconds()
+		m := prometheus.MustNewConstMetric(tempest.ClockSkew, prometheus.GaugeValue, skew, instance)
+		out = append(out, prometheus.NewMetricWithTimestamp(received, m))
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCheck(t *testing.T) {
+	received := time.Unix(1688668741, 0)
+	metrics := func(ts time.Time) []prometheus.Metric {
+		return []prometheus.Metric{
+			prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, 19, "ST-00019709", "air")),
+			prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, 67.63, "ST-00019709")),
+			prometheus.MustNewConstMetric(tempest.StationInfo, prometheus.GaugeValue, 1, "ST-00019709", "Back garden"),
+		}
+	}
+	tests := []struct {
+		name     string
+		ts       time.Time
+		opts     Options
+		wantSkew float64
+		// The timestamp of the readings sent on, or zero if they are dropped
+		wantTs time.Time
+	}{
+		{"in time", received.Add(-2 * time.Second), Options{MaxSkew: time.Minute, Action: Restamp}, 2, received.Add(-2 * time.Second)},
+		{"ahead", received.Add(30 * time.Second), Options{MaxSkew: time.Minute, Action: Restamp}, -30, received.Add(30 * time.Second)},
+		{"no limit", time.Unix(1000, 0), Options{Action: Restamp}, 1688667741, time.Unix(1000, 0)},
+		{"restamped", time.Unix(1000, 0), Options{MaxSkew: time.Minute, Action: Restamp}, 1688667741, received},
+		{"dropped", received.Add(-time.Hour), Options{MaxSkew: time.Minute, Action: Drop}, 3600, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readings int
+			var gotSkew float64
+			for _, m := range Check(metrics(tt.ts), received, tt.opts) {
+				s, err := tempest.NewSample(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch s.Family.Desc {
+				case tempest.ClockSkew:
+					gotSkew = s.Value
+					if s.TimestampMs != received.UnixMilli() {
+						t.Errorf("skew stamped %d, want %d", s.TimestampMs, received.UnixMilli())
+					}
+				case tempest.StationInfo:
+					if s.TimestampMs != 0 {
+						t.Errorf("station info stamped %d", s.TimestampMs)
+					}
+				default:
+					readings++
+					if s.TimestampMs != tt.wantTs.UnixMilli() {
+						t.Errorf("%s stamped %d, want %d", s.Family.Name, s.TimestampMs, tt.wantTs.UnixMilli())
+					}
+				}
+			}
+			if gotSkew != tt.wantSkew {
+				t.Errorf("skew = %v, want %v", gotSkew, tt.wantSkew)
+			}
+			want := 2
+			if tt.wantTs.IsZero() {
+				want = 0
+			}
+			if readings != want {
+				t.Errorf("sent %d readings, want %d", readings, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,484 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/clock"
+	"tempest_exporter/qc"
+	"tempest_exporter/tempestudp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the exporter's configuration, as read from a YAML file and then overridden by environment variables.
+type Config struct {
+	// A WeatherFlow personal access token, used to fetch history
+	Token string `yaml:"token,omitempty"`
+
+	Listen   Listen             `yaml:"listen"`
+	Sinks    Sinks              `yaml:"sinks"`
+	Stations map[string]Station `yaml:"stations,omitempty"`
+	Derived  Derived            `yaml:"derived"`
+	Backfill Backfill           `yaml:"backfill"`
+	Relay    Relay              `yaml:"relay"`
+	Capture  Capture            `yaml:"capture"`
+	Dedup    Dedup              `yaml:"dedup"`
+	Sources  Sources            `yaml:"sources"`
+	QC       QC                 `yaml:"qc"`
+	Clock    Clock              `yaml:"clock"`
+}
+
+type Listen struct {
+	// Addresses on which to receive UDP broadcasts
+	UDP []string `yaml:"udp"`
+
+	// Network interfaces to which each address is bound, or empty for all of them
+	Interfaces []string `yaml:"interfaces,omitempty"`
+
+	// The size of each socket's receive buffer in bytes, or zero for the system default
+	ReceiveBuffer int `yaml:"receive_buffer"`
+
+	// Whether to share the port with other programs on the same host
+	ReusePort bool `yaml:"reuse_port"`
+
+	HTTP      HTTPListen      `yaml:"http"`
+	WebSocket WebSocketListen `yaml:"websocket"`
+}
+
+// HTTPListen configures an HTTP server which accepts messages from elsewhere.
+type HTTPListen struct {
+	Addr string `yaml:"addr,omitempty"`
+
+	// The bearer token which clients must present, if not empty
+	Token string `yaml:"token,omitempty"`
+}
+
+// WebSocketListen configures live observations from the WeatherFlow WebSocket API, for stations beyond the local
+// network. It uses the token.
+type WebSocketListen struct {
+	Enabled bool `yaml:"enabled"`
+
+	// The API's address, or empty for WeatherFlow's
+	URL string `yaml:"url,omitempty"`
+}
+
+// Sinks configures each output. An output is enabled by setting its URL, address, or path.
+type Sinks struct {
+	Pushgateway Pushgateway `yaml:"pushgateway"`
+	RemoteWrite RemoteWrite `yaml:"remote_write"`
+	Influx      Influx      `yaml:"influx"`
+	InfluxUDP   InfluxUDP   `yaml:"influx_udp"`
+	InfluxFile  File        `yaml:"influx_file"`
+	MQTT        MQTT        `yaml:"mqtt"`
+	File        File        `yaml:"file"`
+	Scrape      Scrape      `yaml:"scrape"`
+}
+
+// Filter limits a sink to particular metric families, using shell-style patterns.
+type Filter struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+type Pushgateway struct {
+	URL    string `yaml:"url,omitempty"`
+	Job    string `yaml:"job"`
+	Filter `yaml:",inline"`
+}
+
+type RemoteWrite struct {
+	URL           string        `yaml:"url,omitempty"`
+	Username      string        `yaml:"username,omitempty"`
+	Password      string        `yaml:"password,omitempty"`
+	BearerToken   string        `yaml:"bearer_token,omitempty"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	Filter        `yaml:",inline"`
+}
+
+type Influx struct {
+	URL    string `yaml:"url,omitempty"`
+	Org    string `yaml:"org,omitempty"`
+	Bucket string `yaml:"bucket,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+	Filter `yaml:",inline"`
+}
+
+type InfluxUDP struct {
+	Addr   string `yaml:"addr,omitempty"`
+	Filter `yaml:",inline"`
+}
+
+type File struct {
+	Path   string `yaml:"path,omitempty"`
+	Filter `yaml:",inline"`
+}
+
+type MQTT struct {
+	Broker          string        `yaml:"broker,omitempty"`
+	ClientID        string        `yaml:"client_id,omitempty"`
+	Username        string        `yaml:"username,omitempty"`
+	Password        string        `yaml:"password,omitempty"`
+	TopicPrefix     string        `yaml:"topic_prefix,omitempty"`
+	DiscoveryPrefix string        `yaml:"discovery_prefix,omitempty"`
+	ExpireAfter     time.Duration `yaml:"expire_after"`
+	Filter          `yaml:",inline"`
+}
+
+type Scrape struct {
+	Addr        string        `yaml:"addr,omitempty"`
+	ExpireAfter time.Duration `yaml:"expire_after"`
+	Filter      `yaml:",inline"`
+}
+
+// Station describes a device, keyed by its serial number.
+type Station struct {
+	Name string `yaml:"name,omitempty"`
+
+	// Metres above sea level
+	Elevation *float64 `yaml:"elevation,omitempty"`
+
+	// Adjustments keyed by reading: temperature, humidity, pressure, wind, illuminance, uv, irradiance, or rain
+	Calibration map[string]Adjustment `yaml:"calibration,omitempty"`
+
+	// Whether to also export calibrated readings as they were before calibration
+	ExportUncalibrated bool `yaml:"export_uncalibrated,omitempty"`
+}
+
+type Adjustment struct {
+	Offset float64  `yaml:"offset,omitempty"`
+	Scale  *float64 `yaml:"scale,omitempty"`
+
+	// Pairs of readings and their corrected values, in increasing order of reading
+	Linear [][]float64 `yaml:"linear,omitempty"`
+}
+
+// Derived toggles metrics which are calculated from others rather than measured.
+type Derived struct {
+	WetBulb bool `yaml:"wet_bulb"`
+
+	// Only for stations with an elevation
+	SeaLevelPressure bool `yaml:"sea_level_pressure"`
+
+	// Power save mode, charging trend, and time until the battery runs low
+	Battery bool `yaml:"battery"`
+}
+
+type Backfill struct {
+	Concurrency int     `yaml:"concurrency"`
+	Rate        float64 `yaml:"rate"`
+	BatchSize   int     `yaml:"batch_size"`
+
+	Format        string        `yaml:"format,omitempty"`
+	Dir           string        `yaml:"dir,omitempty"`
+	Filename      string        `yaml:"filename,omitempty"`
+	MaxBytes      int64         `yaml:"max_bytes"`
+	MaxSpan       time.Duration `yaml:"max_span"`
+	BlockDuration time.Duration `yaml:"block_duration"`
+}
+
+// Relay configures the relay command, which forwards UDP broadcasts to exporters elsewhere.
+type Relay struct {
+	// udp://host:port to forward each message unchanged, or an http:// or https:// URL of another exporter's /relay
+	// endpoint
+	Targets []string `yaml:"targets,omitempty"`
+
+	// The bearer token for HTTP targets, if the receiver requires one
+	Token string `yaml:"token,omitempty"`
+
+	// Whether to gzip HTTP streams
+	Compress bool `yaml:"compress"`
+}
+
+// Capture configures recording every received message to a file, which can later be replayed.
+type Capture struct {
+	Path     string        `yaml:"path,omitempty"`
+	MaxBytes int64         `yaml:"max_bytes"`
+	MaxAge   time.Duration `yaml:"max_age"`
+
+	// How many rotated files to keep, or zero to keep them all
+	Keep int `yaml:"keep"`
+}
+
+// Dedup configures dropping messages which arrive more than once, by more than one route or repeated by a hub.
+type Dedup struct {
+	// How long to remember each message, or zero to keep every duplicate
+	Window time.Duration `yaml:"window"`
+
+	// How many messages to remember at most, or zero for no limit
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// Sources limits which messages are accepted, by the address they came from and the hub and device they name.
+type Sources struct {
+	// IP addresses or CIDR prefixes
+	Addresses Filter `yaml:"addresses"`
+
+	// Glob patterns for serial numbers
+	Hubs    Filter `yaml:"hubs"`
+	Devices Filter `yaml:"devices"`
+}
+
+// Options returns the rules for an allowlist.
+func (s Sources) Options() allowlist.Options {
+	rules := func(f Filter) allowlist.Rules {
+		return allowlist.Rules{Include: f.Include, Exclude: f.Exclude}
+	}
+	return allowlist.Options{Addresses: rules(s.Addresses), Hubs: rules(s.Hubs), Devices: rules(s.Devices)}
+}
+
+// QC configures checks for readings which can't be right.
+type QC struct {
+	// "drop" or "flag" readings which fail a check, or empty to check nothing
+	Action string `yaml:"action,omitempty"`
+
+	// Thresholds keyed by kind of reading, such as temperature_air or wind_gust. Those set in the configuration file
+	// are merged onto the defaults, as in mergeQC.
+	Kinds map[string]QCThresholds `yaml:"kinds,omitempty"`
+}
+
+// QCThresholds sets the checks for one kind of reading, in the units of its metric. Unset values are left as they
+// were, and a zero step or flat-line limit skips that check.
+type QCThresholds struct {
+	Min *float64 `yaml:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty"`
+
+	// The largest change between readings a minute apart
+	MaxStep *float64 `yaml:"max_step,omitempty"`
+
+	// The longest a reading can stay exactly the same
+	MaxFlat *time.Duration `yaml:"max_flat,omitempty"`
+}
+
+// Options returns the options for a qc.Checker.
+func (q QC) Options() qc.Options {
+	opts := qc.Options{Action: q.Action, Kinds: make(map[string]qc.Thresholds, len(q.Kinds))}
+	for kind, t := range q.Kinds {
+		thresholds := qc.Thresholds{Min: t.Min, Max: t.Max}
+		if t.MaxStep != nil {
+			thresholds.MaxStep = *t.MaxStep
+		}
+		if t.MaxFlat != nil {
+			thresholds.MaxFlat = *t.MaxFlat
+		}
+		opts.Kinds[kind] = thresholds
+	}
+	return opts
+}
+
+// mergeQC returns the thresholds for each kind, overriding the defaults with each value which is set. Setting none, as
+// with {}, turns off every check for the kind.
+func mergeQC(defaults map[string]QCThresholds, set map[string]QCThresholds) map[string]QCThresholds {
+	out := make(map[string]QCThresholds, len(defaults))
+	for kind, t := range defaults {
+		out[kind] = t
+	}
+	for kind, t := range set {
+		if t == (QCThresholds{}) {
+			out[kind] = t
+			continue
+		}
+		merged := out[kind]
+		if t.Min != nil {
+			merged.Min = t.Min
+		}
+		if t.Max != nil {
+			merged.Max = t.Max
+		}
+		if t.MaxStep != nil {
+			merged.MaxStep = t.MaxStep
+		}
+		if t.MaxFlat != nil {
+			merged.MaxFlat = t.MaxFlat
+		}
+		out[kind] = merged
+	}
+	return out
+}
+
+// defaultQC returns plausible limits for each kind of reading.
+func defaultQC() map[string]QCThresholds {
+	between := func(min, max, maxStep float64, maxFlat time.Duration) QCThresholds {
+		return QCThresholds{Min: &min, Max: &max, MaxStep: &maxStep, MaxFlat: &maxFlat}
+	}
+	return map[string]QCThresholds{
+		"temperature_air": between(-60, 70, 3, 12*time.Hour),
+		"humidity":        between(0, 100, 15, 0),
+		"pressure":        between(50_000, 108_500, 300, 6*time.Hour), // station pressure, low on high ground
+		"wind_lull":       between(0, 75, 25, 0),
+		"wind_avg":        between(0, 75, 25, 0),
+		"wind_gust":       between(0, 90, 40, 0),
+		"wind_rapid":      between(0, 90, 40, 0),
+		"illuminance":     between(0, 200_000, 0, 0),
+		"uv":              between(0, 20, 0, 0),
+		"irradiance":      between(0, 2000, 0, 0),
+		"rain_rate":       between(0, 40, 0, 0),
+		"battery":         between(1, 3.5, 0.5, 0),
+	}
+}
+
+// Clock configures what to do with readings whose timestamps are far from when they were received, as when a hub's
+// clock resets after a brownout.
+type Clock struct {
+	// The largest difference to allow, or zero for no limit
+	MaxSkew time.Duration `yaml:"max_skew"`
+
+	// "restamp" readings beyond the limit with when they were received, or "drop" them
+	Action string `yaml:"action"`
+}
+
+// Options returns the options for clock.Check.
+func (c Clock) Options() clock.Options {
+	return clock.Options{MaxSkew: c.MaxSkew, Action: c.Action}
+}
+
+// Default returns the configuration used when nothing is specified.
+func Default() *Config {
+	return &Config{
+		Listen: Listen{UDP: []string{":50222"}},
+		Sinks: Sinks{
+			Pushgateway: Pushgateway{Job: "tempest"},
+			RemoteWrite: RemoteWrite{BatchSize: 1000, FlushInterval: 5 * time.Second},
+			MQTT:        MQTT{ExpireAfter: 5 * time.Minute},
+			Scrape:      Scrape{ExpireAfter: 5 * time.Minute},
+		},
+		Derived: Derived{WetBulb: true, SeaLevelPressure: true, Battery: true},
+		Backfill: Backfill{
+			Concurrency:   4,
+			Rate:          5,
+			BatchSize:     10_000,
+			MaxBytes:      4 << 20,
+			BlockDuration: 24 * time.Hour,
+		},
+		Capture: Capture{MaxBytes: 64 << 20, Keep: 10},
+		Dedup:   Dedup{Window: 10 * time.Minute, MaxEntries: 100_000},
+		QC:      QC{Kinds: defaultQC()},
+		Clock:   Clock{Action: clock.Restamp},
+	}
+}
+
+// Load reads the configuration file at path, if path is not empty, then applies overrides from the environment and
+// validates the result. The error lists every problem found.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parse(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	errs = append(errs, c.applyEnv(os.LookupEnv)...)
+	errs = append(errs, c.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+func (c *Config) parse(b []byte) error {
+	// The decoder would replace each kind's thresholds entirely, rather than just the values set
+	defaults := c.QC.Kinds
+	c.QC.Kinds = nil
+	defer func() { c.QC.Kinds = mergeQC(defaults, c.QC.Kinds) }()
+
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// ReportOptions returns the options for reports from the device with the given serial number.
+func (c *Config) ReportOptions(serial string) tempestudp.Options {
+	opts := tempestudp.Options{SkipWetBulb: !c.Derived.WetBulb}
+	station, ok := c.Stations[serial]
+	if !ok {
+		return opts
+	}
+
+	opts.Name = station.Name
+	opts.Uncalibrated = station.ExportUncalibrated
+	if station.Elevation != nil {
+		opts.Elevation = *station.Elevation
+		opts.SeaLevelPressure = c.Derived.SeaLevelPressure
+	}
+	for reading, a := range station.Calibration {
+		adjustment := tempestudp.Adjustment{Offset: a.Offset}
+		if a.Scale != nil {
+			adjustment.Scale = *a.Scale
+		}
+		for _, point := range a.Linear {
+			adjustment.Linear = append(adjustment.Linear, tempestudp.Point{Reading: point[0], Corrected: point[1]})
+		}
+		if field := calibrationField(&opts.Calibration, reading); field != nil {
+			*field = adjustment
+		}
+	}
+	return opts
+}
+
+// calibrationField returns the adjustment for a reading, or nil if there is no such reading.
+func calibrationField(c *tempestudp.Calibration, reading string) *tempestudp.Adjustment {
+	switch reading {
+	case "temperature":
+		return &c.Temperature
+	case "humidity":
+		return &c.Humidity
+	case "pressure":
+		return &c.Pressure
+	case "wind":
+		return &c.Wind
+	case "illuminance":
+		return &c.Illuminance
+	case "uv":
+		return &c.UV
+	case "irradiance":
+		return &c.Irradiance
+	case "rain":
+		return &c.Rain
+	default:
+		return nil
+	}
+}
+
+// Redacted returns a copy of the configuration with secrets hidden, suitable for display.
+func (c *Config) Redacted() *Config {
+	out := *c
+	for _, s := range []*string{
+		&out.Token,
+		&out.Listen.HTTP.Token,
+		&out.Relay.Token,
+		&out.Sinks.RemoteWrite.Password,
+		&out.Sinks.RemoteWrite.BearerToken,
+		&out.Sinks.Influx.Token,
+		&out.Sinks.MQTT.Password,
+	} {
+		if *s != "" {
+			*s = "<redacted>"
+		}
+	}
+	return &out
+}
+
+// Marshal encodes the configuration as YAML.
+func (c *Config) Marshal() ([]byte, error) {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(c); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), e.Close()
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tempest_exporter/qc"
+	"tempest_exporter/tempest"
+	"tempest_exporter/tempestudp"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tempest.yaml")
+	if err := os.WriteFile(path, []byte(`
+listen:
+  udp: ["192.168.1.2:50222"]
+sinks:
+  remote_write:
+    url: http://mimir:9009/api/v1/push
+    batch_size: 500
+    exclude: [tempest_rssi_dbm]
+  mqtt:
+    broker: tcp://mosquitto:1883
+stations:
+  ST-00019709:
+    name: Back garden
+    elevation: 120
+    calibration:
+      temperature: {offset: -0.8}
+      rain: {scale: 1.15}
+      humidity: {linear: [[0, 0], [90, 95], [100, 100]]}
+    export_uncalibrated: true
+derived:
+  wet_bulb: false
+qc:
+  action: flag
+  kinds:
+    pressure: {max_step: 100}
+    humidity: {max: 98}
+    rain_rate: {}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tokenPath := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenPath, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKEN_FILE", tokenPath)
+	t.Setenv("REMOTE_WRITE_INTERVAL", "10s")
+	t.Setenv("MQTT_EXCLUDE", "tempest_uptime_*, tempest_rssi_dbm")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Token != "s3cret" {
+		t.Errorf("Token = %q", c.Token)
+	}
+	if !reflect.DeepEqual(c.Listen.UDP, []string{"192.168.1.2:50222"}) {
+		t.Errorf("Listen.UDP = %v", c.Listen.UDP)
+	}
+	rw := c.Sinks.RemoteWrite
+	if rw.BatchSize != 500 || rw.FlushInterval != 10*time.Second || !reflect.DeepEqual(rw.Exclude, []string{"tempest_rssi_dbm"}) {
+		t.Errorf("RemoteWrite = %+v", rw)
+	}
+	if want := []string{"tempest_uptime_*", "tempest_rssi_dbm"}; !reflect.DeepEqual(c.Sinks.MQTT.Exclude, want) {
+		t.Errorf("MQTT.Exclude = %v, want %v", c.Sinks.MQTT.Exclude, want)
+	}
+	// Thresholds which are set override the defaults, while the rest are kept
+	q := c.QC.Options()
+	if q.Action != "flag" {
+		t.Errorf("QC.Action = %q", q.Action)
+	}
+	if p := q.Kinds["pressure"]; p.MaxStep != 100 || p.Min == nil || *p.Min != 50_000 || p.Max == nil || *p.Max != 108_500 || p.MaxFlat != 6*time.Hour {
+		t.Errorf("pressure thresholds = %+v", p)
+	}
+	if h := q.Kinds["humidity"]; h.Max == nil || *h.Max != 98 || h.Min == nil || *h.Min != 0 || h.MaxStep != 15 {
+		t.Errorf("humidity thresholds = %+v", h)
+	}
+	if r := q.Kinds["rain_rate"]; r != (qc.Thresholds{}) {
+		t.Errorf("rain_rate thresholds = %+v, want none", r)
+	}
+	if q.Kinds["temperature_air"].MaxFlat != 12*time.Hour {
+		t.Errorf("temperature_air thresholds = %+v", q.Kinds["temperature_air"])
+	}
+	if c.Sinks.MQTT.ExpireAfter != 5*time.Minute || c.Sinks.Pushgateway.Job != "tempest" || c.Backfill.Concurrency != 4 {
+		t.Error("defaults were not kept")
+	}
+
+	want := tempestudp.Options{
+		Name:      "Back garden",
+		Elevation: 120,
+		Calibration: tempestudp.Calibration{
+			Temperature: tempestudp.Adjustment{Offset: -0.8},
+			Rain:        tempestudp.Adjustment{Scale: 1.15},
+			Humidity:    tempestudp.Adjustment{Linear: []tempestudp.Point{{Reading: 0, Corrected: 0}, {Reading: 90, Corrected: 95}, {Reading: 100, Corrected: 100}}},
+		},
+		Uncalibrated:     true,
+		SkipWetBulb:      true,
+		SeaLevelPressure: true,
+	}
+	if got := c.ReportOptions("ST-00019709"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReportOptions() = %+v, want %+v", got, want)
+	}
+	if got := c.ReportOptions("ST-00000001"); !reflect.DeepEqual(got, tempestudp.Options{SkipWetBulb: true}) {
+		t.Errorf("ReportOptions() for an unknown station = %+v", got)
+	}
+
+	if b, err := c.Redacted().Marshal(); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(b), "s3cret") {
+		t.Errorf("redacted configuration contains the token:\n%s", b)
+	}
+	if c.Token != "s3cret" {
+		t.Error("Redacted modified the original")
+	}
+}
+
+func TestLoad_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name:    "unknown field",
+			yaml:    "sinks:\n  scrape:\n    adr: :9574\n",
+			wantErr: []string{"line 3: field adr not found"},
+		},
+		{
+			name:    "bad env",
+			env:     map[string]string{"REMOTE_WRITE_BATCH_SIZE": "lots", "BACKFILL_RATE": "fast"},
+			wantErr: []string{"REMOTE_WRITE_BATCH_SIZE: ", "BACKFILL_RATE: "},
+		},
+		{
+			name:    "backfill filename without a sequence number",
+			env:     map[string]string{"BACKFILL_FILENAME": "tempest.txt.gz"},
+			wantErr: []string{`backfill.filename: "tempest.txt.gz" must format the sequence number exactly once`},
+		},
+		{
+			name:    "missing secret file",
+			env:     map[string]string{"INFLUX_TOKEN_FILE": "/nonexistent"},
+			wantErr: []string{"INFLUX_TOKEN_FILE: "},
+		},
+		{
+			name:    "websocket without a token",
+			env:     map[string]string{"LISTEN_WEBSOCKET": "true", "LISTEN_WEBSOCKET_URL": "https://ws.example.com"},
+			wantErr: []string{"listen.websocket.enabled: requires a token", "listen.websocket.url: "},
+		},
+		{
+			name: "every problem reported",
+			yaml: `
+listen:
+  udp: [":http-ish"]
+sinks:
+  influx:
+    url: influx:8086
+  mqtt:
+    broker: tcp://mosquitto:1883
+    include: ["tempest_["]
+stations:
+  ST-00019709:
+    elevation: 12000
+    calibration:
+      snow: {offset: 1}
+      rain: {scale: 0}
+      humidity: {linear: [[0, 0], [100, 100, 1]]}
+      wind: {linear: [[10, 10], [5, 5]]}
+backfill:
+  format: csv
+  filename: tempest_%s.txt
+dedup:
+  max_entries: -1
+sources:
+  addresses:
+    include: ["192.168.1.0/24", "gateway"]
+qc:
+  action: discard
+  kinds:
+    snow: {max: 100}
+    humidity: {min: 100, max: 0}
+clock:
+  action: ignore
+`,
+			wantErr: []string{
+				`listen.udp[0]: invalid port "http-ish"`,
+				"sinks.influx.url: ",
+				"sinks.influx.org: is required",
+				"sinks.influx.bucket: is required",
+				"sinks.mqtt.include: invalid pattern",
+				"stations.ST-00019709.elevation: ",
+				`stations.ST-00019709.calibration: unknown reading "snow"`,
+				"stations.ST-00019709.calibration.rain.scale: must not be zero",
+				"stations.ST-00019709.calibration.humidity.linear[1]: must be a reading and its corrected value",
+				"stations.ST-00019709.calibration.wind.linear[1]: readings must increase",
+				"backfill.format: ",
+				`backfill.filename: "tempest_%s.txt" may only format the sequence number`,
+				"dedup.max_entries: must not be negative",
+				"sources.addresses.include[1]: ",
+				`qc.action: must be "drop" or "flag", not "discard"`,
+				`qc.kinds: unknown kind of reading "snow"`,
+				"qc.kinds.humidity: min must not be greater than max",
+				`clock.action: must be "restamp" or "drop", not "ignore"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var path string
+			if tt.yaml != "" {
+				path = filepath.Join(t.TempDir(), "tempest.yaml")
+				if err := os.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := Load(path)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't mention %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDefault_qcHighElevation(t *testing.T) {
+	// A station in Mexico City, 2240 m up, where station pressure is around 770 hPa
+	elevation := 2240.0
+	c := Default()
+	c.Stations = map[string]Station{"ST-00019709": {Elevation: &elevation}}
+	c.QC.Action = qc.Drop
+	checker := qc.New(c.QC.Options())
+
+	msg := `{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.18,0.63,1.29,105,3,771.2,19.5,71,0,0,0,0,0,0,0,2.63,1]],"firmware_revision":171}`
+	report, err := tempestudp.ParseReportWithOptions([]byte(msg), c.ReportOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, m := range checker.Check(report.Metrics()) {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[s.Family.Name+"/"+s.Label("kind")] = true
+	}
+	for _, want := range []string{"tempest_pressure_pa/", "tempest_sea_level_pressure_pa/", "tempest_temperature_c/wetbulb"} {
+		if !got[want] {
+			t.Errorf("%s dropped", want)
+		}
+	}
+}
+
+func TestLoad_example(t *testing.T) {
+	if _, err := Load("../tempest.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
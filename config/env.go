@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envVar overrides a setting with the value of an environment variable.
+type envVar struct {
+	name string
+	set  func(string) error
+}
+
+func (c *Config) envVars() []envVar {
+	s := &c.Sinks
+	b := &c.Backfill
+	vars := []envVar{
+		{"TOKEN", str(&c.Token)},
+		{"LISTEN_ADDR", list(&c.Listen.UDP)},
+		{"LISTEN_INTERFACES", list(&c.Listen.Interfaces)},
+		{"LISTEN_RECEIVE_BUFFER", integer(&c.Listen.ReceiveBuffer)},
+		{"LISTEN_REUSE_PORT", boolean(&c.Listen.ReusePort)},
+		{"LISTEN_HTTP_ADDR", str(&c.Listen.HTTP.Addr)},
+		{"LISTEN_HTTP_TOKEN", str(&c.Listen.HTTP.Token)},
+		{"LISTEN_WEBSOCKET", boolean(&c.Listen.WebSocket.Enabled)},
+		{"LISTEN_WEBSOCKET_URL", str(&c.Listen.WebSocket.URL)},
+
+		{"PUSH_URL", str(&s.Pushgateway.URL)},
+		{"JOB_NAME", str(&s.Pushgateway.Job)},
+
+		{"REMOTE_WRITE_URL", str(&s.RemoteWrite.URL)},
+		{"REMOTE_WRITE_USERNAME", str(&s.RemoteWrite.Username)},
+		{"REMOTE_WRITE_PASSWORD", str(&s.RemoteWrite.Password)},
+		{"REMOTE_WRITE_BEARER_TOKEN", str(&s.RemoteWrite.BearerToken)},
+		{"REMOTE_WRITE_BATCH_SIZE", integer(&s.RemoteWrite.BatchSize)},
+		{"REMOTE_WRITE_INTERVAL", duration(&s.RemoteWrite.FlushInterval)},
+
+		{"INFLUX_URL", str(&s.Influx.URL)},
+		{"INFLUX_ORG", str(&s.Influx.Org)},
+		{"INFLUX_BUCKET", str(&s.Influx.Bucket)},
+		{"INFLUX_TOKEN", str(&s.Influx.Token)},
+		{"INFLUX_UDP_ADDR", str(&s.InfluxUDP.Addr)},
+		{"INFLUX_FILE", str(&s.InfluxFile.Path)},
+
+		{"MQTT_BROKER", str(&s.MQTT.Broker)},
+		{"MQTT_CLIENT_ID", str(&s.MQTT.ClientID)},
+		{"MQTT_USERNAME", str(&s.MQTT.Username)},
+		{"MQTT_PASSWORD", str(&s.MQTT.Password)},
+		{"MQTT_TOPIC_PREFIX", str(&s.MQTT.TopicPrefix)},
+		{"MQTT_DISCOVERY_PREFIX", str(&s.MQTT.DiscoveryPrefix)},
+		{"MQTT_EXPIRE_AFTER", duration(&s.MQTT.ExpireAfter)},
+
+		{"FILE", str(&s.File.Path)},
+		{"SCRAPE_ADDR", str(&s.Scrape.Addr)},
+		{"SCRAPE_EXPIRE_AFTER", duration(&s.Scrape.ExpireAfter)},
+
+		{"DERIVED_WET_BULB", boolean(&c.Derived.WetBulb)},
+		{"DERIVED_SEA_LEVEL_PRESSURE", boolean(&c.Derived.SeaLevelPressure)},
+		{"DERIVED_BATTERY", boolean(&c.Derived.Battery)},
+
+		{"BACKFILL_CONCURRENCY", integer(&b.Concurrency)},
+		{"BACKFILL_RATE", float(&b.Rate)},
+		{"BACKFILL_BATCH_SIZE", integer(&b.BatchSize)},
+		{"BACKFILL_FORMAT", str(&b.Format)},
+		{"BACKFILL_DIR", str(&b.Dir)},
+		{"BACKFILL_FILENAME", str(&b.Filename)},
+		{"BACKFILL_MAX_BYTES", integer64(&b.MaxBytes)},
+		{"BACKFILL_MAX_SPAN", duration(&b.MaxSpan)},
+		{"BACKFILL_BLOCK_DURATION", duration(&b.BlockDuration)},
+
+		{"RELAY_TARGETS", list(&c.Relay.Targets)},
+		{"RELAY_TOKEN", str(&c.Relay.Token)},
+		{"RELAY_COMPRESS", boolean(&c.Relay.Compress)},
+
+		{"CAPTURE_PATH", str(&c.Capture.Path)},
+		{"CAPTURE_MAX_BYTES", integer64(&c.Capture.MaxBytes)},
+		{"CAPTURE_MAX_AGE", duration(&c.Capture.MaxAge)},
+		{"CAPTURE_KEEP", integer(&c.Capture.Keep)},
+		{"DEDUP_WINDOW", duration(&c.Dedup.Window)},
+		{"DEDUP_MAX_ENTRIES", integer(&c.Dedup.MaxEntries)},
+		{"QC_ACTION", str(&c.QC.Action)},
+		{"CLOCK_MAX_SKEW", duration(&c.Clock.MaxSkew)},
+		{"CLOCK_SKEW_ACTION", str(&c.Clock.Action)},
+	}
+
+	for _, f := range []struct {
+		prefix string
+		filter *Filter
+	}{
+		{"PUSH", &s.Pushgateway.Filter},
+		{"REMOTE_WRITE", &s.RemoteWrite.Filter},
+		{"INFLUX", &s.Influx.Filter},
+		{"INFLUX_UDP", &s.InfluxUDP.Filter},
+		{"INFLUX_FILE", &s.InfluxFile.Filter},
+		{"MQTT", &s.MQTT.Filter},
+		{"FILE", &s.File.Filter},
+		{"SCRAPE", &s.Scrape.Filter},
+		{"SOURCE_ADDRESSES", &c.Sources.Addresses},
+		{"SOURCE_HUBS", &c.Sources.Hubs},
+		{"SOURCE_DEVICES", &c.Sources.Devices},
+	} {
+		vars = append(vars,
+			envVar{f.prefix + "_INCLUDE", list(&f.filter.Include)},
+			envVar{f.prefix + "_EXCLUDE", list(&f.filter.Exclude)},
+		)
+	}
+	return vars
+}
+
+// applyEnv overrides settings from the environment. Any variable can instead be read from a file named by the same
+// variable with a _FILE suffix, which is useful for secrets.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) []error {
+	var errs []error
+	for _, v := range c.envVars() {
+		value, ok, err := lookupEnv(lookup, v.name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.name, err))
+		}
+	}
+	return errs
+}
+
+func lookupEnv(lookup func(string) (string, bool), name string) (string, bool, error) {
+	if value, ok := lookup(name); ok && value != "" {
+		return value, true, nil
+	}
+	if path, ok := lookup(name + "_FILE"); ok && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	}
+	return "", false, nil
+}
+
+func str(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+// list parses a comma-separated list.
+func list(p *[]string) func(string) error {
+	return func(s string) error {
+		*p = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+func integer(p *int) func(string) error {
+	return func(s string) error {
+		v, err := strconv.Atoi(s)
+		if err == nil {
+			*p = v
+		}
+		return err
+	}
+}
+
+func integer64(p *int64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err == nil {
+			*p = v
+		}
+		return err
+	}
+}
+
+func float(p *float64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			*p = v
+		}
+		return err
+	}
+}
+
+func duration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		v, err := time.ParseDuration(s)
+		if err == nil {
+			*p = v
+		}
+		return err
+	}
+}
+
+func boolean(p *bool) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseBool(s)
+		if err == nil {
+			*p = v
+		}
+		return err
+	}
+}
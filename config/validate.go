@@ -0,0 +1,341 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/clock"
+	"tempest_exporter/qc"
+	"tempest_exporter/tempestudp"
+)
+
+// problems collects validation errors, each naming the setting at fault.
+type problems []error
+
+func (p *problems) add(setting string, format string, args ...interface{}) {
+	*p = append(*p, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+}
+
+func (c *Config) validate() []error {
+	var p problems
+
+	if len(c.Listen.UDP) == 0 {
+		p.add("listen.udp", "at least one address is required")
+	}
+	for i, addr := range c.Listen.UDP {
+		p.checkAddr(fmt.Sprintf("listen.udp[%d]", i), addr)
+	}
+	for i, name := range c.Listen.Interfaces {
+		if name == "" {
+			p.add(fmt.Sprintf("listen.interfaces[%d]", i), "must not be empty")
+		}
+	}
+	if c.Listen.ReceiveBuffer < 0 {
+		p.add("listen.receive_buffer", "must not be negative")
+	}
+	if c.Listen.HTTP.Addr != "" {
+		p.checkAddr("listen.http.addr", c.Listen.HTTP.Addr)
+	}
+	if c.Listen.WebSocket.Enabled && c.Token == "" {
+		p.add("listen.websocket.enabled", "requires a token")
+	}
+	if c.Listen.WebSocket.URL != "" {
+		p.checkURL("listen.websocket.url", c.Listen.WebSocket.URL, "ws", "wss")
+	}
+
+	s := &c.Sinks
+	if s.Pushgateway.URL != "" {
+		p.checkURL("sinks.pushgateway.url", s.Pushgateway.URL, "http", "https")
+		if s.Pushgateway.Job == "" {
+			p.add("sinks.pushgateway.job", "must not be empty")
+		}
+	}
+	p.checkFilter("sinks.pushgateway", s.Pushgateway.Filter)
+
+	if s.RemoteWrite.URL != "" {
+		p.checkURL("sinks.remote_write.url", s.RemoteWrite.URL, "http", "https")
+	}
+	if s.RemoteWrite.BearerToken != "" && (s.RemoteWrite.Username != "" || s.RemoteWrite.Password != "") {
+		p.add("sinks.remote_write", "bearer_token can't be combined with username and password")
+	}
+	if s.RemoteWrite.BatchSize <= 0 {
+		p.add("sinks.remote_write.batch_size", "must be positive")
+	}
+	if s.RemoteWrite.FlushInterval < 0 {
+		p.add("sinks.remote_write.flush_interval", "must not be negative")
+	}
+	p.checkFilter("sinks.remote_write", s.RemoteWrite.Filter)
+
+	if s.Influx.URL != "" {
+		p.checkURL("sinks.influx.url", s.Influx.URL, "http", "https")
+		if s.Influx.Org == "" {
+			p.add("sinks.influx.org", "is required with a URL")
+		}
+		if s.Influx.Bucket == "" {
+			p.add("sinks.influx.bucket", "is required with a URL")
+		}
+	}
+	p.checkFilter("sinks.influx", s.Influx.Filter)
+
+	if s.InfluxUDP.Addr != "" {
+		p.checkAddr("sinks.influx_udp.addr", s.InfluxUDP.Addr)
+	}
+	p.checkFilter("sinks.influx_udp", s.InfluxUDP.Filter)
+	p.checkFilter("sinks.influx_file", s.InfluxFile.Filter)
+
+	if s.MQTT.Broker != "" {
+		p.checkURL("sinks.mqtt.broker", s.MQTT.Broker, "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss")
+	}
+	if s.MQTT.ExpireAfter <= 0 {
+		p.add("sinks.mqtt.expire_after", "must be positive")
+	}
+	p.checkFilter("sinks.mqtt", s.MQTT.Filter)
+	p.checkFilter("sinks.file", s.File.Filter)
+
+	if s.Scrape.Addr != "" {
+		p.checkAddr("sinks.scrape.addr", s.Scrape.Addr)
+	}
+	if s.Scrape.ExpireAfter <= 0 {
+		p.add("sinks.scrape.expire_after", "must be positive")
+	}
+	p.checkFilter("sinks.scrape", s.Scrape.Filter)
+
+	serials := make([]string, 0, len(c.Stations))
+	for serial := range c.Stations {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	for _, serial := range serials {
+		p.checkStation("stations."+serial, c.Stations[serial])
+	}
+
+	b := &c.Backfill
+	if b.Concurrency <= 0 {
+		p.add("backfill.concurrency", "must be positive")
+	}
+	if b.Rate < 0 {
+		p.add("backfill.rate", "must not be negative")
+	}
+	if b.BatchSize <= 0 {
+		p.add("backfill.batch_size", "must be positive")
+	}
+	switch b.Format {
+	case "", "text", "openmetrics", "tsdb":
+	default:
+		p.add("backfill.format", "must be text, openmetrics, or tsdb, not %q", b.Format)
+	}
+	if b.Filename != "" {
+		p.checkPattern("backfill.filename", b.Filename)
+	}
+	if b.MaxBytes < 0 {
+		p.add("backfill.max_bytes", "must not be negative")
+	}
+	if b.MaxSpan < 0 {
+		p.add("backfill.max_span", "must not be negative")
+	}
+	if b.BlockDuration <= 0 {
+		p.add("backfill.block_duration", "must be positive")
+	}
+
+	if c.Capture.MaxBytes < 0 {
+		p.add("capture.max_bytes", "must not be negative")
+	}
+	if c.Capture.MaxAge < 0 {
+		p.add("capture.max_age", "must not be negative")
+	}
+	if c.Capture.Keep < 0 {
+		p.add("capture.keep", "must not be negative")
+	}
+	if c.Dedup.Window < 0 {
+		p.add("dedup.window", "must not be negative")
+	}
+	if c.Dedup.MaxEntries < 0 {
+		p.add("dedup.max_entries", "must not be negative")
+	}
+	for i, addr := range c.Sources.Addresses.Include {
+		if _, err := allowlist.ParseAddress(addr); err != nil {
+			p.add(fmt.Sprintf("sources.addresses.include[%d]", i), "%v", err)
+		}
+	}
+	for i, addr := range c.Sources.Addresses.Exclude {
+		if _, err := allowlist.ParseAddress(addr); err != nil {
+			p.add(fmt.Sprintf("sources.addresses.exclude[%d]", i), "%v", err)
+		}
+	}
+	p.checkFilter("sources.hubs", c.Sources.Hubs)
+	p.checkFilter("sources.devices", c.Sources.Devices)
+	p.checkQC(c.QC)
+	if c.Clock.MaxSkew < 0 {
+		p.add("clock.max_skew", "must not be negative")
+	}
+	if c.Clock.Action != clock.Restamp && c.Clock.Action != clock.Drop {
+		p.add("clock.action", "must be %q or %q, not %q", clock.Restamp, clock.Drop, c.Clock.Action)
+	}
+
+	for i, target := range c.Relay.Targets {
+		setting := fmt.Sprintf("relay.targets[%d]", i)
+		u, err := url.Parse(target)
+		if err != nil {
+			p.add(setting, "%v", err)
+			continue
+		}
+		switch u.Scheme {
+		case "udp":
+			p.checkAddr(setting, u.Host)
+		case "http", "https":
+			p.checkURL(setting, target, "http", "https")
+		default:
+			p.add(setting, "%q must use one of the schemes [udp http https]", target)
+		}
+	}
+
+	return p
+}
+
+func (p *problems) checkAddr(setting string, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		p.add(setting, "%v", err)
+		return
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || (n == 0 && port != "0") {
+		p.add(setting, "invalid port %q", port)
+	}
+}
+
+func (p *problems) checkURL(setting string, s string, schemes ...string) {
+	u, err := url.Parse(s)
+	if err != nil {
+		p.add(setting, "%v", err)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			if u.Host == "" {
+				p.add(setting, "%q has no host", s)
+			}
+			return
+		}
+	}
+	p.add(setting, "%q must use one of the schemes %v", s, schemes)
+}
+
+func (p *problems) checkFilter(setting string, f Filter) {
+	for _, pattern := range f.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			p.add(setting+".include", "invalid pattern %q", pattern)
+		}
+	}
+	for _, pattern := range f.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			p.add(setting+".exclude", "invalid pattern %q", pattern)
+		}
+	}
+}
+
+func (p *problems) checkStation(setting string, s Station) {
+	if s.Elevation != nil && (*s.Elevation < -500 || *s.Elevation > 9000) {
+		p.add(setting+".elevation", "%g m is implausible", *s.Elevation)
+	}
+
+	readings := make([]string, 0, len(s.Calibration))
+	for reading := range s.Calibration {
+		readings = append(readings, reading)
+	}
+	sort.Strings(readings)
+	var c tempestudp.Calibration
+	for _, reading := range readings {
+		if calibrationField(&c, reading) == nil {
+			p.add(setting+".calibration", "unknown reading %q", reading)
+			continue
+		}
+		if scale := s.Calibration[reading].Scale; scale != nil && *scale == 0 {
+			p.add(setting+".calibration."+reading+".scale", "must not be zero")
+		}
+		p.checkLinear(setting+".calibration."+reading+".linear", s.Calibration[reading].Linear)
+	}
+}
+
+func (p *problems) checkQC(q QC) {
+	switch q.Action {
+	case "", qc.Drop, qc.Flag:
+	default:
+		p.add("qc.action", "must be %q or %q, not %q", qc.Drop, qc.Flag, q.Action)
+	}
+
+	kinds := make([]string, 0, len(q.Kinds))
+	for kind := range q.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		setting := "qc.kinds." + kind
+		known := false
+		for _, k := range qc.Kinds {
+			known = known || k == kind
+		}
+		if !known {
+			p.add("qc.kinds", "unknown kind of reading %q", kind)
+			continue
+		}
+		t := q.Kinds[kind]
+		if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+			p.add(setting, "min must not be greater than max")
+		}
+		if t.MaxStep != nil && *t.MaxStep < 0 {
+			p.add(setting+".max_step", "must not be negative")
+		}
+		if t.MaxFlat != nil && *t.MaxFlat < 0 {
+			p.add(setting+".max_flat", "must not be negative")
+		}
+	}
+}
+
+func (p *problems) checkLinear(setting string, points [][]float64) {
+	if len(points) == 1 {
+		p.add(setting, "needs at least two points")
+	}
+	for i, point := range points {
+		if len(point) != 2 {
+			p.add(fmt.Sprintf("%s[%d]", setting, i), "must be a reading and its corrected value")
+			return
+		}
+		if i > 0 && point[0] <= points[i-1][0] {
+			p.add(fmt.Sprintf("%s[%d]", setting, i), "readings must increase")
+		}
+	}
+}
+
+// checkPattern checks a file name pattern formats the file's sequence number exactly once, like tempest_%03d.txt.gz.
+func (p *problems) checkPattern(setting string, pattern string) {
+	verbs := 0
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(pattern) && strings.IndexByte("+-# .0123456789", pattern[i]) >= 0 {
+			i++
+		}
+		switch {
+		case i == len(pattern):
+			p.add(setting, "%q ends with an incomplete verb", pattern)
+			return
+		case pattern[i] == '%':
+		case strings.IndexByte("bdoxX", pattern[i]) >= 0:
+			verbs++
+		default:
+			p.add(setting, "%q may only format the sequence number, with a verb like %%d", pattern)
+			return
+		}
+	}
+	if verbs != 1 {
+		p.add(setting, "%q must format the sequence number exactly once, with a verb like %%d", pattern)
+	}
+}
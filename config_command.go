@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"tempest_exporter/config"
+)
+
+// configCommand implements "config check", which reports any problems with the configuration, or otherwise prints the
+// configuration in effect with secrets redacted.
+func configCommand(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("config", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "check" {
+		fs.Usage()
+		return usageError{fmt.Errorf("expected \"check\"")}
+	}
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		return configError{err}
+	}
+	b, err := cfg.Redacted().Marshal()
+	if err != nil {
+		return fmt.Errorf("error encoding configuration: %w", err)
+	}
+	if _, err := os.Stdout.Write(b); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "configuration OK")
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package dedup recognises messages which have already been received, such as an observation arriving both by UDP and
+// over the WebSocket API, from two hubs, or repeated by the same hub.
+package dedup
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Filter remembers the messages it has seen for a window of time, up to a limit. A nil Filter remembers nothing.
+type Filter struct {
+	window     time.Duration
+	maxEntries int
+
+	mu sync.Mutex
+
+	// When each message was seen, and the same in the order they were seen, oldest first
+	seen  map[key]time.Time
+	order []entry
+
+	suppressed uint64
+}
+
+// key identifies a message by its device, type, and when it was measured. Hub status messages are also numbered.
+type key struct {
+	serial    string
+	typ       string
+	timestamp int64
+	seq       int64
+}
+
+type entry struct {
+	key key
+	at  time.Time
+}
+
+// New returns a Filter which remembers messages for window, forgetting the oldest early once it remembers maxEntries,
+// if that's positive.
+func New(window time.Duration, maxEntries int) *Filter {
+	return &Filter{window: window, maxEntries: maxEntries, seen: make(map[key]time.Time)}
+}
+
+// Duplicate returns whether the message was already seen within the window, remembering it if not. Messages without
+// a serial number or timestamp are never duplicates.
+func (f *Filter) Duplicate(msg []byte, now time.Time) bool {
+	if f == nil {
+		return false
+	}
+	k, ok := keyOf(msg)
+	if !ok {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(now)
+	if at, ok := f.seen[k]; ok && now.Sub(at) < f.window {
+		f.suppressed++
+		return true
+	}
+	f.seen[k] = now
+	f.order = append(f.order, entry{key: k, at: now})
+	return false
+}
+
+// Suppressed returns how many duplicates have been found.
+func (f *Filter) Suppressed() uint64 {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.suppressed
+}
+
+// Len returns how many messages are remembered.
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.seen)
+}
+
+// expire forgets messages seen before the window, and the oldest beyond the limit, leaving room for one more.
+func (f *Filter) expire(now time.Time) {
+	var n int
+	for n < len(f.order) {
+		e := f.order[n]
+		full := f.maxEntries > 0 && len(f.order)-n >= f.maxEntries
+		if !full && now.Sub(e.at) < f.window {
+			break
+		}
+		// The message may have been seen again since, after the window
+		if f.seen[e.key] == e.at {
+			delete(f.seen, e.key)
+		}
+		n++
+	}
+	f.order = f.order[n:]
+}
+
+func keyOf(msg []byte) (key, bool) {
+	var m struct {
+		SerialNumber string      `json:"serial_number"`
+		Type         string      `json:"type"`
+		Timestamp    int64       `json:"timestamp"`
+		Seq          int64       `json:"seq"`
+		Ob           []float64   `json:"ob"`
+		Evt          []float64   `json:"evt"`
+		Obs          [][]float64 `json:"obs"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil || m.SerialNumber == "" {
+		return key{}, false
+	}
+
+	k := key{serial: m.SerialNumber, typ: m.Type, timestamp: m.Timestamp}
+	switch {
+	case m.Type == "hub_status":
+		k.seq = m.Seq
+	case len(m.Ob) > 0:
+		k.timestamp = int64(m.Ob[0])
+	case len(m.Evt) > 0:
+		k.timestamp = int64(m.Evt[0])
+	case len(m.Obs) > 0 && len(m.Obs[0]) > 0:
+		k.timestamp = int64(m.Obs[0][0])
+	}
+	return k, k.timestamp != 0
+}
@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFilter_Duplicate(t *testing.T) {
+	const (
+		wind  = `{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}`
+		later = `{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668575,0.91,118]}`
+		obs   = `{"serial_number":"ST-00019709","type":"obs_st","obs":[[1688668572,0,0,0,0,3,1000,20,50,0,0,0,0,0,0,0,2.6,1]]}`
+		other = `{"serial_number":"ST-00019710","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}`
+		hub   = `{"serial_number":"HB-00031344","type":"hub_status","timestamp":1688668572,"seq":%d,"radio_stats":[25,1,0,3,2839]}`
+	)
+	f := New(time.Minute, 0)
+	now := time.Unix(1688668572, 0)
+	steps := []struct {
+		msg   string
+		after time.Duration
+		want  bool
+	}{
+		{wind, 0, false},
+		{wind, time.Second, true},
+		{later, time.Second, false},
+		{obs, 0, false},
+		{other, 0, false},
+		{fmt.Sprintf(hub, 1), 0, false},
+		{fmt.Sprintf(hub, 1), 0, true},
+		{fmt.Sprintf(hub, 2), 0, false},
+		{`{"type":"hub_status"}`, 0, false},
+		{`{"type":"hub_status"}`, 0, false},
+		{wind, time.Minute, false},
+		{wind, time.Second, true},
+	}
+	for i, s := range steps {
+		now = now.Add(s.after)
+		if got := f.Duplicate([]byte(s.msg), now); got != s.want {
+			t.Errorf("step %d: Duplicate(%s) = %v, want %v", i, s.msg, got, s.want)
+		}
+	}
+	if got := f.Suppressed(); got != 3 {
+		t.Errorf("Suppressed() = %d, want 3", got)
+	}
+}
+
+func TestFilter_maxEntries(t *testing.T) {
+	f := New(time.Hour, 100)
+	now := time.Unix(1688668572, 0)
+	msg := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"serial_number":"ST-00019709","type":"rapid_wind","ob":[%d,0.85,113]}`, 1688668572+i))
+	}
+	for i := 0; i < 1000; i++ {
+		if f.Duplicate(msg(i), now) {
+			t.Fatalf("message %d is a duplicate", i)
+		}
+	}
+	if got := f.Len(); got != 100 {
+		t.Errorf("Len() = %d, want 100", got)
+	}
+
+	// The most recent are remembered, and the oldest forgotten
+	if !f.Duplicate(msg(999), now) {
+		t.Error("the last message isn't a duplicate")
+	}
+	if f.Duplicate(msg(0), now) {
+		t.Error("the first message is still remembered")
+	}
+}
+
+func TestFilter_nil(t *testing.T) {
+	var f *Filter
+	if f.Duplicate([]byte(`{"serial_number":"ST-00019709","type":"rapid_wind","ob":[1688668572,0.85,113]}`), time.Now()) {
+		t.Error("a nil Filter found a duplicate")
+	}
+	if f.Suppressed() != 0 || f.Len() != 0 {
+		t.Errorf("a nil Filter has Suppressed() = %d, Len() = %d", f.Suppressed(), f.Len())
+	}
+}
@@ -0,0 +1,109 @@
+package influx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"tempest_exporter/remote"
+	"tempest_exporter/tempest"
+)
+
+// HTTPClient writes samples to an InfluxDB v2 /api/v2/write endpoint.
+type HTTPClient struct {
+	opts  remote.Options
+	token string
+}
+
+// NewHTTPClient returns a client for the InfluxDB server at baseURL, authenticating with token if it is not empty.
+func NewHTTPClient(baseURL string, org string, bucket string, token string) (*HTTPClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	return &HTTPClient{opts: remote.Options{URL: u.String()}, token: token}, nil
+}
+
+func (c *HTTPClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	if c.token != "" {
+		header.Set("Authorization", "Token "+c.token)
+	}
+	return remote.Post(ctx, c.opts, appendLines(nil, samples), header)
+}
+
+// UDPClient writes samples to an InfluxDB or Telegraf UDP line protocol listener.
+type UDPClient struct {
+	conn net.Conn
+}
+
+// maxDatagram keeps each datagram within a typical Ethernet MTU, so they arrive unfragmented
+const maxDatagram = 1400
+
+func NewUDPClient(addr string) (*UDPClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPClient{conn: conn}, nil
+}
+
+func (c *UDPClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	var datagram, line []byte
+	for _, s := range samples {
+		line = AppendLine(line[:0], s)
+		if len(datagram) > 0 && len(datagram)+len(line) > maxDatagram {
+			if _, err := c.conn.Write(datagram); err != nil {
+				return err
+			}
+			datagram = datagram[:0]
+		}
+		datagram = append(datagram, line...)
+	}
+	if len(datagram) > 0 {
+		if _, err := c.conn.Write(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *UDPClient) Close() error {
+	return c.conn.Close()
+}
+
+// FileClient appends samples to a file.
+type FileClient struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileClient(name string) (*FileClient, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileClient{f: f}, nil
+}
+
+func (c *FileClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.f.Write(appendLines(nil, samples))
+	return err
+}
+
+func (c *FileClient) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,153 @@
+package influx
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func sample(t *testing.T, m prometheus.Metric) tempest.Sample {
+	t.Helper()
+	s, err := tempest.NewSample(prometheus.NewMetricWithTimestamp(time.Unix(1688668741, 0), m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAppendLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric prometheus.Metric
+		want   string
+	}{
+		{
+			"typical",
+			prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, 19.5, "ST-00019709", "air"),
+			"tempest_temperature_c,instance=ST-00019709,kind=air value=19.5 1688668741000000000\n",
+		},
+		{
+			"escaping",
+			prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, 67.63, "a b,c=d"),
+			"tempest_humidity_percent,instance=a\\ b\\,c\\=d value=67.63 1688668741000000000\n",
+		},
+		{
+			"empty tag",
+			prometheus.MustNewConstMetric(tempest.Battery, prometheus.GaugeValue, 2.792, ""),
+			"tempest_battery_volts value=2.792 1688668741000000000\n",
+		},
+		{
+			"not finite",
+			prometheus.MustNewConstMetric(tempest.Battery, prometheus.GaugeValue, math.NaN(), "ST-00019709"),
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendLine(nil, sample(t, tt.metric))); got != tt.want {
+				t.Errorf("AppendLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func testSamples(t *testing.T) []tempest.Sample {
+	return []tempest.Sample{
+		sample(t, prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, 19.5, "ST-00019709", "air")),
+		sample(t, prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, 67.63, "ST-00019709")),
+	}
+}
+
+const wantLines = "tempest_temperature_c,instance=ST-00019709,kind=air value=19.5 1688668741000000000\n" +
+	"tempest_humidity_percent,instance=ST-00019709 value=67.63 1688668741000000000\n"
+
+func TestHTTPClient(t *testing.T) {
+	var gotPath, gotQuery, gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotQuery, gotAuth, gotBody = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewHTTPClient(srv.URL, "home", "weather", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), testSamples(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotPath != "/api/v2/write" || gotQuery != "bucket=weather&org=home&precision=ns" {
+		t.Errorf("request = %s?%s", gotPath, gotQuery)
+	}
+	if gotAuth != "Token secret" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if gotBody != wantLines {
+		t.Errorf("body = %q, want %q", gotBody, wantLines)
+	}
+}
+
+func TestUDPClient(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := NewUDPClient(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Send(context.Background(), testSamples(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != wantLines {
+		t.Errorf("datagram = %q, want %q", got, wantLines)
+	}
+}
+
+func TestFileClient(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "tempest.lp")
+
+	c, err := NewFileClient(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Send(context.Background(), testSamples(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != wantLines+wantLines {
+		t.Errorf("file = %q, want %q", got, wantLines+wantLines)
+	}
+}
@@ -0,0 +1,51 @@
+package influx
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"tempest_exporter/tempest"
+)
+
+// Docs: https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// AppendLine appends the sample to b as a line of InfluxDB line protocol. Each metric family is a measurement, each
+// label is a tag, and the value is a field named "value". Samples which are not finite are skipped, since line protocol
+// has no way to represent them.
+func AppendLine(b []byte, s tempest.Sample) []byte {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return b
+	}
+
+	b = append(b, measurementEscaper.Replace(s.Family.Name)...)
+	for _, label := range s.Labels {
+		if label.Value == "" {
+			// Tags cannot be empty
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, tagEscaper.Replace(label.Name)...)
+		b = append(b, '=')
+		b = append(b, tagEscaper.Replace(label.Value)...)
+	}
+	b = append(b, " value="...)
+	b = strconv.AppendFloat(b, s.Value, 'g', -1, 64)
+	if s.TimestampMs != 0 {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, s.TimestampMs*1_000_000, 10)
+	}
+	return append(b, '\n')
+}
+
+func appendLines(b []byte, samples []tempest.Sample) []byte {
+	for _, s := range samples {
+		b = AppendLine(b, s)
+	}
+	return b
+}
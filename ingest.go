@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+
+	"tempest_exporter/relay"
+)
+
+// The largest request body accepted by /ingest
+const maxIngestBytes = 16 << 20
+
+// ingestResult is the response to an ingest request.
+type ingestResult struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Errors   []ingestError `json:"errors,omitempty"`
+}
+
+// ingestError explains why a message was rejected.
+type ingestError struct {
+	// The position of the message in the request, counting from zero and ignoring blank lines
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ingestHandler accepts Tempest UDP messages over HTTP, either as newline-delimited JSON or as a JSON array, and passes
+// each one to rx along with the client's address. If token is not empty, requests must carry it as a bearer token.
+//
+// The response reports how many messages were accepted and why any were rejected. The status is 200 if every message
+// was accepted, 422 if any were rejected, and 400 if the request could not be read at all.
+func ingestHandler(token string, rx func([]byte, *net.UDPAddr) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !relay.Authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		messages, err := splitMessages(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		addr, _ := net.ResolveUDPAddr("udp", r.RemoteAddr)
+		var result ingestResult
+		for i, msg := range messages {
+			if err := rx(msg, addr); err != nil {
+				result.Rejected++
+				result.Errors = append(result.Errors, ingestError{Index: i, Error: err.Error()})
+			} else {
+				result.Accepted++
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if result.Rejected > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// splitMessages splits a request body into messages. A body starting with "[" is a JSON array of messages, and
+// anything else has one message per line.
+func splitMessages(body []byte) ([][]byte, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var array []json.RawMessage
+		if err := json.Unmarshal(trimmed, &array); err != nil {
+			return nil, err
+		}
+		messages := make([][]byte, len(array))
+		for i, msg := range array {
+			messages[i] = msg
+		}
+		return messages, nil
+	}
+
+	var messages [][]byte
+	s := bufio.NewScanner(bytes.NewReader(body))
+	s.Buffer(nil, maxIngestBytes)
+	for s.Scan() {
+		if line := bytes.TrimSpace(s.Bytes()); len(line) > 0 {
+			messages = append(messages, line)
+		}
+	}
+	return messages, s.Err()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tempest_exporter/config"
+)
+
+func Test_ingestHandler(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(ingestHandler("s3cret", func(b []byte, addr *net.UDPAddr) error {
+		if addr == nil || !addr.IP.IsLoopback() {
+			t.Errorf("addr = %v", addr)
+		}
+		if strings.Contains(string(b), "bogus") {
+			return errors.New(`unhandled message type: "bogus"`)
+		}
+		received = append(received, string(b))
+		return nil
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+		want       ingestResult
+		received   int
+	}{
+		{"unauthorized", "", `{"type":"rapid_wind"}`, http.StatusUnauthorized, ingestResult{}, 0},
+		{"ndjson", "s3cret", "{\"type\":\"rapid_wind\"}\n\n{\"type\":\"obs_st\"}\n", http.StatusOK, ingestResult{Accepted: 2}, 2},
+		{"array", "s3cret", `[{"type":"rapid_wind"}, {"type":"bogus"}, {"type":"obs_st"}]`, http.StatusUnprocessableEntity, ingestResult{
+			Accepted: 2,
+			Rejected: 1,
+			Errors:   []ingestError{{Index: 1, Error: `unhandled message type: "bogus"`}},
+		}, 2},
+		{"malformed array", "s3cret", `[{"type":"rapid_wind"}`, http.StatusBadRequest, ingestResult{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(received) != tt.received {
+				t.Errorf("received %d messages, want %d", len(received), tt.received)
+			}
+			if resp.Header.Get("Content-Type") == "application/json" {
+				var got ingestResult
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_ingestHandler_hubStatusWithoutRadioStats(t *testing.T) {
+	cfg := config.Default()
+	pipe := newPipeline(cfg)
+	srv := httptest.NewServer(ingestHandler("s3cret", func(b []byte, addr *net.UDPAddr) error {
+		_, err := pipe.metrics(cfg, b, time.Now())
+		return err
+	}))
+	defer srv.Close()
+
+	body := `{"serial_number":"HB-00031344","type":"hub_status","firmware_revision":"171","uptime":1670133,"rssi":-62,"timestamp":1688668572,"reset_flags":"BOR,PIN,POR","seq":48,"fs":[1,0,15675411,524288]}`
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var got ingestResult
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ingestResult{Accepted: 1}) {
+		t.Errorf("got %+v, want %+v", got, ingestResult{Accepted: 1})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"tempest_exporter/config"
+)
+
+// The largest possible UDP payload, so that nothing is truncated
+const maxDatagram = 65535
+
+// listen receives datagrams on every configured socket, calling rx for each, until ctx is done or reading fails.
+func listen(ctx context.Context, cfg config.Listen, rx func([]byte, *net.UDPAddr) error) error {
+	socks, err := openSockets(ctx, cfg)
+	defer func() {
+		for _, sock := range socks {
+			sock.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	readErr := make(chan error, len(socks))
+
+	// Start reading in the background
+	for _, sock := range socks {
+		go func(sock *net.UDPConn) {
+			buffer := make([]byte, maxDatagram)
+			for {
+				n, addr, err := sock.ReadFromUDP(buffer)
+				if err != nil {
+					readErr <- err
+					break
+				}
+				err = rx(buffer[:n], addr)
+				if err != nil {
+					readErr <- err
+					break
+				}
+			}
+		}(sock)
+	}
+
+	// Wait for reading to fail, or for our context to finish
+	select {
+	case err := <-readErr:
+		return err
+
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// openSockets opens the sockets described by the configuration, or takes those passed in by systemd. Each address is
+// bound once per configured interface. On error, any sockets already opened are returned so they can be closed.
+func openSockets(ctx context.Context, cfg config.Listen) ([]*net.UDPConn, error) {
+	socks, err := systemdSockets()
+	if err != nil || len(socks) > 0 {
+		for _, sock := range socks {
+			log.Printf("listening on UDP %s, passed in by systemd", sock.LocalAddr())
+		}
+		return socks, setReceiveBuffer(socks, cfg.ReceiveBuffer)
+	}
+
+	interfaces := cfg.Interfaces
+	if len(interfaces) == 0 {
+		interfaces = []string{""}
+	}
+	for _, addr := range cfg.UDP {
+		for _, iface := range interfaces {
+			lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+				var err error
+				if cerr := c.Control(func(fd uintptr) {
+					err = setSockopts(fd, iface, cfg.ReusePort)
+				}); cerr != nil {
+					return cerr
+				}
+				return err
+			}}
+			conn, err := lc.ListenPacket(ctx, "udp", addr)
+			if err != nil {
+				if iface != "" {
+					err = fmt.Errorf("%s on %s: %w", addr, iface, err)
+				}
+				return socks, err
+			}
+			sock := conn.(*net.UDPConn)
+			socks = append(socks, sock)
+			if iface != "" {
+				log.Printf("listening on UDP %s via %s", sock.LocalAddr(), iface)
+			} else {
+				log.Printf("listening on UDP %s", sock.LocalAddr())
+			}
+		}
+	}
+	return socks, setReceiveBuffer(socks, cfg.ReceiveBuffer)
+}
+
+func setReceiveBuffer(socks []*net.UDPConn, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	for _, sock := range socks {
+		if err := sock.SetReadBuffer(size); err != nil {
+			return fmt.Errorf("setting the receive buffer of %s: %w", sock.LocalAddr(), err)
+		}
+	}
+	return nil
+}
+
+// The first file descriptor passed by systemd socket activation
+const systemdFirstFD = 3
+
+// systemdSockets returns the UDP sockets passed in by systemd socket activation, if any, as described in
+// sd_listen_fds(3).
+func systemdSockets() ([]*net.UDPConn, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Don't pass these on to anything we start
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var socks []*net.UDPConn
+	for i := 0; i < n; i++ {
+		name := "systemd"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(systemdFirstFD+i), name)
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return socks, fmt.Errorf("socket %q passed in by systemd: %w", name, err)
+		}
+		sock, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return socks, fmt.Errorf("socket %q passed in by systemd: %w", name, errNotUDP)
+		}
+		socks = append(socks, sock)
+	}
+	return socks, nil
+}
+
+var errNotUDP = errors.New("not a UDP socket")
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"tempest_exporter/config"
+)
+
+func Test_openSockets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+
+	// Find a free port
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().String()
+	probe.Close()
+
+	// Both sockets can share the port
+	socks, err := openSockets(context.Background(), config.Listen{
+		UDP:           []string{addr, addr},
+		ReceiveBuffer: 1 << 20,
+		ReusePort:     true,
+	})
+	defer func() {
+		for _, sock := range socks {
+			sock.Close()
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(socks) != 2 {
+		t.Fatalf("opened %d sockets, want 2", len(socks))
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kernel delivers the datagram to one of them
+	received := make(chan string, 2)
+	for _, sock := range socks {
+		sock.SetReadDeadline(time.Now().Add(time.Second))
+		go func(sock *net.UDPConn) {
+			b := make([]byte, maxDatagram)
+			if n, _, err := sock.ReadFromUDP(b); err == nil {
+				received <- string(b[:n])
+			}
+		}(sock)
+	}
+	select {
+	case got := <-received:
+		if got != "hello" {
+			t.Errorf("received %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	// Without SO_REUSEPORT, the second socket can't be bound
+	more, err := openSockets(context.Background(), config.Listen{UDP: []string{addr}})
+	for _, sock := range more {
+		sock.Close()
+	}
+	if err == nil {
+		t.Error("expected an error binding a port in use")
+	}
+}
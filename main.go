@@ -1,242 +1,210 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
 	"os"
 	"os/signal"
-	"time"
+	"sort"
+	"strings"
+	"syscall"
 
-	"tempest_exporter/tempest"
-	"tempest_exporter/tempestapi"
-	"tempest_exporter/tempestudp"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/push"
-	"github.com/prometheus/common/expfmt"
+	"tempest_exporter/config"
 )
 
-type collector struct {
-	outbox <-chan prometheus.Metric
-}
+// Exit codes, so that scripts and service managers can tell a bad configuration, which won't fix itself, from a
+// failure which might.
+const (
+	exitOK      = 0
+	exitRuntime = 1
+	exitUsage   = 2
+	exitConfig  = 3
+)
 
-func (c collector) Describe(descs chan<- *prometheus.Desc) {
-	for _, desc := range tempest.All {
-		descs <- desc
-	}
+type command struct {
+	args string
+	help string
+	run  func(ctx context.Context, configPath string, args []string) error
 }
 
-func (c collector) Collect(metrics chan<- prometheus.Metric) {
-	for {
-		select {
-		case m := <-c.outbox:
-			metrics <- m
-		default:
-			return
-		}
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve": {
+			help: "Listen for UDP broadcasts and send metrics to the configured outputs. This is the default command.",
+			run:  serve,
+		},
+		"backfill": {
+			help: "Fetch the observation history of every station on the account and write or send it.",
+			run:  backfillCommand,
+		},
+		"replay": {
+			args: "[FILE...]",
+			help: "Send Tempest UDP messages from JSON, capture, or pcap files, or stdin, to the configured outputs.",
+			run:  replay,
+		},
+		"relay": {
+			help: "Listen for UDP broadcasts and forward them to exporters elsewhere, as configured under relay.",
+			run:  relayCommand,
+		},
+		"simulate": {
+			help: "Send messages from virtual stations, with simulated weather, for testing without a real one.",
+			run:  simulate,
+		},
+		"stations": {
+			help: "List the stations and devices on the account, noting which ones are skipped.",
+			run:  stations,
+		},
+		"parse": {
+			args: "[FILE...]",
+			help: "Decode Tempest UDP messages from JSON, capture, or pcap files, or stdin, and print the resulting metrics.",
+			run:  parse,
+		},
+		"config": {
+			args: "check",
+			help: "Check the configuration, printing the settings in effect with secrets redacted.",
+			run:  configCommand,
+		},
 	}
 }
 
 func main() {
-	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer done()
-
-	token := os.Getenv("TOKEN")
-	if token != "" {
-		export(ctx, token)
-	} else {
-		listenAndPush(ctx)
-	}
+	os.Exit(run(os.Args[1:]))
 }
 
-func listenAndPush(ctx context.Context) {
-	pushUrl := os.Getenv("PUSH_URL")
-	if pushUrl == "" {
-		log.Fatal("PUSH_URL must be specified")
-	}
-	jobName := os.Getenv("JOB_NAME")
-	if jobName == "" {
-		jobName = "tempest"
-	}
-	log.Printf("pushing to %q with job name %q", pushUrl, jobName)
-
-	more := make(chan bool, 1)
-	outbox := make(chan prometheus.Metric, 1000)
-	go func() {
-		c := &collector{outbox}
-		pusher := push.New(pushUrl, jobName).Collector(c).Format(expfmt.FmtText)
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-more:
-				if err := pusher.Add(); err != nil {
-					log.Printf("error pushing: %v", err)
-				}
-			}
-		}
-	}()
-
-	if err := listen(ctx, func(b []byte, addr *net.UDPAddr) error {
-		log.Printf("UDP in: %s", string(b))
-		report, err := tempestudp.ParseReport(b)
-		if err != nil {
-			log.Printf("error parsing report from %s: %s", addr, err)
-		} else {
-			for _, m := range report.Metrics() {
-				outbox <- m
-			}
-
-			select {
-			case more <- true:
-				// success
-			default:
-				// already busy sending
-			}
+func run(args []string) int {
+	fs := flag.NewFlagSet("tempest_exporter", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG"), "path to a YAML configuration file")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
-
-		return nil
-	}); err != nil {
-		log.Fatal(err)
+		return exitUsage
 	}
-}
 
-func listen(ctx context.Context, rx func([]byte, *net.UDPAddr) error) error {
-	sock, err := net.ListenUDP("udp", &net.UDPAddr{
-		IP:   nil,
-		Port: 50222,
-	})
-	if err != nil {
-		return err
+	name, args := "serve", fs.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
-	defer sock.Close()
-	log.Printf("listening on UDP :50222")
-
-	readErr := make(chan error, 1)
-
-	// Start reading in the background
-	go func() {
-		buffer := make([]byte, 1500)
-		for {
-			n, addr, err := sock.ReadFromUDP(buffer)
-			if err != nil {
-				readErr <- err
-				break
-			}
-			err = rx(buffer[:n], addr)
-			if err != nil {
-				readErr <- err
-				break
-			}
+	if name == "help" {
+		if len(args) == 0 {
+			usage(fs)
+			return exitOK
 		}
-		close(readErr)
-	}()
+		name, args = args[0], []string{"-help"}
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(fs)
+		return exitUsage
+	}
 
-	// Wait for reading to finish, or for our context to finish
-	select {
-	case err := <-readErr:
-		return err
+	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer done()
 
-	case <-ctx.Done():
-		return nil
+	err := cmd.run(ctx, *configPath, args)
+	var ce configError
+	var ue usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.As(err, &ce):
+		log.Printf("invalid configuration:\n%v", ce.err)
+		return exitConfig
+	default:
+		log.Print(err)
+		return exitRuntime
 	}
 }
 
-func export(ctx context.Context, token string) {
-	client := tempestapi.NewClient(token)
-	stations, err := client.ListStations(ctx)
-	if err != nil {
-		log.Fatalf("error listing stations: %v", err)
-	}
-
-	if len(stations) == 0 {
-		log.Fatalf("no stations found")
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "usage: tempest_exporter [-config FILE] <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-
-	log.Printf("found stations:")
-	var startAt time.Time
-	for _, station := range stations {
-		log.Printf("  - %s (station #%d)", station.Name, station.StationID)
-		if startAt.IsZero() || startAt.Before(station.CreatedAt) {
-			startAt = station.CreatedAt
-		}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].help)
 	}
+	fmt.Fprintf(out, "\nRun \"tempest_exporter <command> -help\" for a command's flags.\n\nFlags:\n")
+	fs.PrintDefaults()
+}
 
-	n := 1
-
-	var next time.Time
-	cur := startAt
-	for {
-		var c dumpCollector
-
-		for ; cur.Before(time.Now()) && len(c.metrics) < 200_000; cur = next {
-			next = cur.AddDate(0, 0, 1) // for 1-minute observation frequency
+// configError reports a problem with the configuration.
+type configError struct {
+	err error
+}
 
-			for _, station := range stations {
-				log.Printf("fetching %s starting %s", station.Name, cur.Format(time.RFC3339))
-				metrics, err := client.GetObservations(ctx, station, cur, next)
-				if err != nil {
-					log.Fatalf("error fetching %#v for %d-%d: %v", station, cur.Unix(), next.Unix(), err)
-				}
-				c.metrics = append(c.metrics, metrics...)
-			}
-		}
+func (e configError) Error() string {
+	return "invalid configuration: " + e.err.Error()
+}
 
-		if len(c.metrics) == 0 {
-			break
-		}
+// usageError reports bad flags or arguments, which have already been explained to the user.
+type usageError struct {
+	err error
+}
 
-		r := prometheus.NewRegistry()
-		r.MustRegister(&c)
-		families, err := r.Gather()
-		if err != nil {
-			log.Fatalf("error gathering metrics: %v", err)
-		}
+func (e usageError) Error() string {
+	return e.err.Error()
+}
 
-		filename := fmt.Sprintf("tempest_%03d.txt.gz", n)
-		log.Printf("writing %s", filename)
-		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatalf("error opening output file: %v", err)
-		}
-		gzw := gzip.NewWriter(f)
-		enc := expfmt.NewEncoder(gzw, expfmt.FmtText)
-		for _, family := range families {
-			if err := enc.Encode(family); err != nil {
-				log.Fatalf("error encoding metrics: %v", err)
-			}
-		}
-		if c, ok := enc.(io.Closer); ok {
-			err = c.Close()
-			if err != nil {
-				log.Fatalf("error closing metric encoder: %v", err)
-			}
-		}
-		if err := gzw.Close(); err != nil {
-			log.Fatalf("error closing gzip writer: %v", err)
-		}
-		if err := f.Close(); err != nil {
-			log.Fatalf("error closing output file: %v", err)
+// commandFlags returns the flags for a command, including -config, which defaults to configPath.
+func commandFlags(name string, configPath string) (*flag.FlagSet, *string) {
+	cmd := commands[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", configPath, "path to a YAML configuration file")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "usage: tempest_exporter %s", name)
+		if cmd.args != "" {
+			fmt.Fprintf(out, " [flags] %s", cmd.args)
+		} else {
+			fmt.Fprintf(out, " [flags]")
 		}
-
-		n = n + 1
+		fmt.Fprintf(out, "\n\n%s\n\nFlags:\n", cmd.help)
+		fs.PrintDefaults()
 	}
+	return fs, path
 }
 
-type dumpCollector struct {
-	metrics []prometheus.Metric
+// parseFlags parses a command's flags.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err}
+	}
+	return nil
 }
 
-func (d dumpCollector) Describe(descs chan<- *prometheus.Desc) {
+// loadConfig loads the configuration, reporting any problem as a configuration error.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, configError{err}
+	}
+	return cfg, nil
 }
 
-func (d dumpCollector) Collect(metrics chan<- prometheus.Metric) {
-	for _, metric := range d.metrics {
-		metrics <- metric
+// noArgs rejects arguments for commands which take none.
+func noArgs(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return usageError{fmt.Errorf("unexpected arguments")}
 	}
+	return nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_run(t *testing.T) {
+	dir := t.TempDir()
+	packets := filepath.Join(dir, "packets.ndjson")
+	if err := os.WriteFile(packets, []byte(`{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}
+{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668575,0.91,118]}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	captured := filepath.Join(dir, "capture.ndjson")
+	if err := os.WriteFile(captured, []byte(`{"time":"2023-07-06T18:36:12.5Z","source":"192.168.1.50:50222","message":{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}}
+{"time":"2023-07-06T18:36:15.5Z","source":"192.168.1.50:50222","message":{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668575,0.91,118]}}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	implausible := filepath.Join(dir, "implausible.ndjson")
+	if err := os.WriteFile(implausible, []byte(`{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.18,0.63,1.29,105,3,1014.72,19.5,104,0,0,0,0,0,0,0,2.63,1]],"firmware_revision":171}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("backfill:\n  format: csv\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.txt")
+	capturedOut := filepath.Join(dir, "captured.txt")
+	checkedOut := filepath.Join(dir, "checked.txt")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want int
+	}{
+		{"help", []string{"-help"}, nil, exitOK},
+		{"command help", []string{"help", "replay"}, nil, exitOK},
+		{"unknown command", []string{"frobnicate"}, nil, exitUsage},
+		{"unknown flag", []string{"serve", "-frobnicate"}, nil, exitUsage},
+		{"unexpected argument", []string{"serve", "now"}, nil, exitUsage},
+		{"invalid config file", []string{"-config", invalid, "config", "check"}, nil, exitConfig},
+		{"invalid config file after command", []string{"config", "-config", invalid, "check"}, nil, exitConfig},
+		{"missing config file", []string{"parse", "-config", filepath.Join(dir, "missing.yaml"), packets}, nil, exitConfig},
+		{"invalid environment", []string{"parse", packets}, map[string]string{"BACKFILL_RATE": "fast"}, exitConfig},
+		{"no outputs", []string{"serve"}, nil, exitConfig},
+		{"no token", []string{"backfill"}, nil, exitConfig},
+		{"no relay targets", []string{"relay"}, nil, exitConfig},
+		{"invalid relay target", []string{"relay"}, map[string]string{"RELAY_TARGETS": "tcp://exporter:9875"}, exitConfig},
+		{"invalid relay sources", []string{"relay"}, map[string]string{"RELAY_TARGETS": "http://127.0.0.1:9/relay", "SOURCE_ADDRESSES_INCLUDE": "gateway"}, exitConfig},
+		{"config check", []string{"config", "check"}, nil, exitOK},
+		{"parse", []string{"parse", packets}, nil, exitOK},
+		{"parse missing file", []string{"parse", filepath.Join(dir, "missing.ndjson")}, nil, exitRuntime},
+		{"replay", []string{"replay", packets}, map[string]string{"FILE": out}, exitOK},
+		{"replay capture", []string{"replay", "-speed", "100", captured}, map[string]string{"FILE": capturedOut}, exitOK},
+		{"replay checked", []string{"replay", implausible}, map[string]string{"FILE": checkedOut, "QC_ACTION": "flag"}, exitOK},
+		{"simulate", []string{"simulate", "-target", "-", "-speed", "max", "-duration", "1m", "-scenario", "storm"}, nil, exitOK},
+		{"simulate forever at max speed", []string{"simulate", "-speed", "max"}, nil, exitUsage},
+		{"simulate unknown scenario", []string{"simulate", "-scenario", "hurricane"}, nil, exitUsage},
+		{"replay invalid speed", []string{"replay", "-speed", "fast", captured}, map[string]string{"FILE": capturedOut}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if got := run(tt.args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+
+	// Captured messages are compared with when they were received, as serve did
+	for name, want := range map[string]string{
+		out: `tempest_wind_ms{instance="ST-00019709",kind="rapid"} 0.85 1688668572000
+tempest_wind_direction_degrees{instance="ST-00019709"} 113 1688668572000
+tempest_wind_ms{instance="ST-00019709",kind="rapid"} 0.91 1688668575000
+tempest_wind_direction_degrees{instance="ST-00019709"} 118 1688668575000
+`,
+		capturedOut: `tempest_wind_ms{instance="ST-00019709",kind="rapid"} 0.85 1688668572000
+tempest_wind_direction_degrees{instance="ST-00019709"} 113 1688668572000
+tempest_clock_skew_seconds{instance="ST-00019709"} 0.5 1688668572500
+tempest_wind_ms{instance="ST-00019709",kind="rapid"} 0.91 1688668575000
+tempest_wind_direction_degrees{instance="ST-00019709"} 118 1688668575000
+tempest_clock_skew_seconds{instance="ST-00019709"} 0.5 1688668575500
+`,
+	} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("replayed:\n%s\nwant:\n%s", b, want)
+		}
+	}
+
+	// Replayed readings are checked, as serve would
+	b, err := os.ReadFile(checkedOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `tempest_humidity_percent{instance="ST-00019709",quality="range"} 104 1688668741000`; !strings.Contains(string(b), want) {
+		t.Errorf("replayed:\n%s\nwant a line:\n%s", b, want)
+	}
+}
+
+func Test_pacer(t *testing.T) {
+	p := pacer{speed: 60}
+	start := time.Now()
+	received := time.Unix(1688668572, 0)
+	for _, offset := range []time.Duration{0, 3 * time.Second, 6 * time.Second} {
+		if err := p.wait(context.Background(), received.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Six seconds at 60 times the speed
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("took %v, want about 100ms", elapsed)
+	}
+}
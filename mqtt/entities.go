@@ -0,0 +1,67 @@
+package mqtt
+
+import (
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// entity describes how a metric is presented to Home Assistant as a sensor.
+//
+// Docs: https://www.home-assistant.io/integrations/sensor.mqtt/
+type entity struct {
+	key         string
+	name        string
+	deviceClass string
+	stateClass  string
+	unit        string
+	icon        string
+
+	// Values are multiplied by scale to convert them to unit
+	scale float64
+
+	diagnostic bool
+}
+
+type entityKey struct {
+	desc *prometheus.Desc
+	kind string
+}
+
+var entities map[entityKey]entity
+
+func init() {
+	measurement := func(key, name, deviceClass, unit string) entity {
+		return entity{key: key, name: name, deviceClass: deviceClass, stateClass: "measurement", unit: unit, scale: 1}
+	}
+
+	entities = map[entityKey]entity{
+		{tempest.Uptime, ""}:    {key: "uptime", name: "Uptime", deviceClass: "duration", stateClass: "total_increasing", unit: "s", scale: 1, diagnostic: true},
+		{tempest.Rssi, ""}:      {key: "rssi", name: "Signal strength", deviceClass: "signal_strength", stateClass: "measurement", unit: "dBm", scale: 1, diagnostic: true},
+		{tempest.Reboots, ""}:   {key: "reboots", name: "Reboots", stateClass: "total_increasing", icon: "mdi:restart", scale: 1, diagnostic: true},
+		{tempest.BusErrors, ""}: {key: "bus_errors", name: "Bus errors", stateClass: "total_increasing", icon: "mdi:alert-circle-outline", scale: 1, diagnostic: true},
+
+		{tempest.Illuminance, ""}:        measurement("illuminance", "Illuminance", "illuminance", "lx"),
+		{tempest.UV, ""}:                 {key: "uv", name: "UV index", stateClass: "measurement", icon: "mdi:sun-wireless", scale: 1},
+		{tempest.RainRate, ""}:           {key: "rain_rate", name: "Rain rate", deviceClass: "precipitation_intensity", stateClass: "measurement", unit: "mm/h", scale: 60},
+		{tempest.Wind, "lull"}:           measurement("wind_lull", "Wind lull", "wind_speed", "m/s"),
+		{tempest.Wind, "avg"}:            measurement("wind_avg", "Wind speed", "wind_speed", "m/s"),
+		{tempest.Wind, "gust"}:           measurement("wind_gust", "Wind gust", "wind_speed", "m/s"),
+		{tempest.Wind, "rapid"}:          measurement("wind_rapid", "Wind speed (rapid)", "wind_speed", "m/s"),
+		{tempest.WindDirection, ""}:      {key: "wind_direction", name: "Wind direction", stateClass: "measurement", unit: "°", icon: "mdi:compass-outline", scale: 1},
+		{tempest.Battery, ""}:            {key: "battery", name: "Battery", deviceClass: "voltage", stateClass: "measurement", unit: "V", scale: 1, diagnostic: true},
+		{tempest.ReportInterval, ""}:     {key: "report_interval", name: "Report interval", deviceClass: "duration", unit: "s", scale: 1, diagnostic: true},
+		{tempest.Irradiance, ""}:         measurement("irradiance", "Solar irradiance", "irradiance", "W/m²"),
+		{tempest.RainTotal, ""}:          {key: "rain_total", name: "Rainfall", deviceClass: "precipitation", stateClass: "total_increasing", unit: "mm", scale: 1},
+		{tempest.Pressure, ""}:           {key: "pressure", name: "Station pressure", deviceClass: "atmospheric_pressure", stateClass: "measurement", unit: "hPa", scale: 0.01},
+		{tempest.SeaLevelPressure, ""}:   {key: "sea_level_pressure", name: "Sea level pressure", deviceClass: "atmospheric_pressure", stateClass: "measurement", unit: "hPa", scale: 0.01},
+		{tempest.Temperature, "air"}:     measurement("temperature_air", "Temperature", "temperature", "°C"),
+		{tempest.Temperature, "wetbulb"}: measurement("temperature_wetbulb", "Wet bulb temperature", "temperature", "°C"),
+		{tempest.Humidity, ""}:           measurement("humidity", "Humidity", "humidity", "%"),
+	}
+}
+
+func lookupEntity(s tempest.Sample) (entity, bool) {
+	e, ok := entities[entityKey{s.Family.Desc, s.Label("kind")}]
+	return e, ok
+}
@@ -0,0 +1,359 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Options struct {
+	// The broker to connect to, e.g. "tcp://localhost:1883"
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+
+	// The prefix for state and availability topics, defaulting to "tempest"
+	TopicPrefix string
+
+	// The prefix Home Assistant watches for discovery messages, defaulting to "homeassistant"
+	DiscoveryPrefix string
+
+	// How long a device may go without reporting before it is marked unavailable, defaulting to 5 minutes
+	ExpireAfter time.Duration
+
+	// How long to wait between attempts to connect while the broker can't be reached at first, defaulting to 10
+	// seconds. Once connected, a lost connection is retried with backoff.
+	ConnectRetryInterval time.Duration
+}
+
+// Publisher publishes the latest values for each device to MQTT, along with Home Assistant discovery messages which
+// describe them.
+//
+// Topics:
+//
+//	<prefix>/status                  "online" while the exporter is connected, "offline" otherwise
+//	<prefix>/<serial>/availability   "online" while the device is reporting, "offline" otherwise
+//	<prefix>/<serial>/state          a JSON object holding the latest value of each sensor
+type Publisher struct {
+	opts   Options
+	client paho.Client
+
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+type device struct {
+	serial   string
+	values   map[string]value
+	entities map[string]entity
+	lastSeen time.Time
+	online   bool
+}
+
+type value struct {
+	v           float64
+	timestampMs int64
+}
+
+func New(opts Options) *Publisher {
+	if opts.ClientID == "" {
+		opts.ClientID = "tempest_exporter"
+	}
+	if opts.TopicPrefix == "" {
+		opts.TopicPrefix = "tempest"
+	}
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+	if opts.ExpireAfter == 0 {
+		opts.ExpireAfter = 5 * time.Minute
+	}
+	if opts.ConnectRetryInterval == 0 {
+		opts.ConnectRetryInterval = 10 * time.Second
+	}
+
+	p := &Publisher{
+		opts:    opts,
+		devices: make(map[string]*device),
+	}
+
+	co := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(opts.ConnectRetryInterval).
+		SetWill(p.statusTopic(), "offline", 1, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("lost connection to MQTT broker: %v", err)
+		})
+	p.client = paho.NewClient(co)
+	return p
+}
+
+func (p *Publisher) statusTopic() string {
+	return p.opts.TopicPrefix + "/status"
+}
+
+func (p *Publisher) deviceTopic(serial string, suffix string) string {
+	return p.opts.TopicPrefix + "/" + serial + "/" + suffix
+}
+
+// Run connects to the broker and keeps device availability up to date until ctx is done, at which point it marks
+// everything offline and disconnects. If the broker can't be reached, it keeps trying in the background, and messages
+// published meanwhile are sent once connected.
+func (p *Publisher) Run(ctx context.Context) error {
+	log.Printf("connecting to MQTT broker %s", p.opts.Broker)
+	connected := p.client.Connect()
+	go func() {
+		// With retries, this only completes once connected, or once disconnected
+		if connected.Wait() && connected.Error() != nil {
+			log.Printf("error connecting to MQTT broker %s: %v", p.opts.Broker, connected.Error())
+		}
+	}()
+
+	ticker := time.NewTicker(p.opts.ExpireAfter / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.expire(time.Now())
+
+		case <-ctx.Done():
+			if !p.client.IsConnectionOpen() {
+				p.client.Disconnect(0)
+				return nil
+			}
+
+			// Wait for these to be delivered, since disconnecting abandons anything in flight
+			var tokens []paho.Token
+			p.mu.Lock()
+			for _, d := range p.devices {
+				tokens = append(tokens, p.client.Publish(p.deviceTopic(d.serial, "availability"), 1, true, "offline"))
+			}
+			p.mu.Unlock()
+			tokens = append(tokens, p.client.Publish(p.statusTopic(), 1, true, "offline"))
+			for _, t := range tokens {
+				t.WaitTimeout(5 * time.Second)
+			}
+
+			p.client.Disconnect(1000)
+			return nil
+		}
+	}
+}
+
+// onConnect announces everything again, since the broker may have lost retained messages while we were disconnected.
+func (p *Publisher) onConnect(c paho.Client) {
+	log.Printf("connected to MQTT broker %s", p.opts.Broker)
+	p.publish(p.statusTopic(), "online")
+
+	// Home Assistant announces when it starts, at which point it needs discovery messages again
+	c.Subscribe(p.opts.DiscoveryPrefix+"/status", 0, func(_ paho.Client, m paho.Message) {
+		if string(m.Payload()) == "online" {
+			p.announceAll()
+		}
+	})
+
+	p.announceAll()
+}
+
+func (p *Publisher) announceAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.devices {
+		for _, e := range d.entities {
+			p.announce(d, e)
+		}
+		p.publishAvailability(d)
+		p.publishState(d)
+	}
+}
+
+// Send publishes metrics, so a Publisher can be used as a sink.
+func (p *Publisher) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	p.Publish(metrics)
+	return nil
+}
+
+// Publish updates the latest values of each device from metrics and publishes their state.
+func (p *Publisher) Publish(metrics []prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	updated := make(map[*device]bool)
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			log.Printf("error converting metric: %v", err)
+			continue
+		}
+		e, ok := lookupEntity(s)
+		if !ok {
+			continue
+		}
+
+		serial := s.Label("instance")
+		d := p.devices[serial]
+		if d == nil {
+			d = &device{
+				serial:   serial,
+				values:   make(map[string]value),
+				entities: make(map[string]entity),
+			}
+			p.devices[serial] = d
+		}
+
+		if _, ok := d.entities[e.key]; !ok {
+			d.entities[e.key] = e
+			p.announce(d, e)
+		}
+		if prev, ok := d.values[e.key]; ok && prev.timestampMs > s.TimestampMs {
+			// Don't let a late report overwrite a newer value
+			continue
+		}
+		d.values[e.key] = value{v: s.Value * e.scale, timestampMs: s.TimestampMs}
+		d.lastSeen = now
+		updated[d] = true
+	}
+
+	for d := range updated {
+		if !d.online {
+			d.online = true
+			p.publishAvailability(d)
+		}
+		p.publishState(d)
+	}
+}
+
+func (p *Publisher) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.devices {
+		if d.online && now.Sub(d.lastSeen) > p.opts.ExpireAfter {
+			log.Printf("%s has not reported since %s, marking it unavailable", d.serial, d.lastSeen.Format(time.RFC3339))
+			d.online = false
+			p.publishAvailability(d)
+		}
+	}
+}
+
+func (p *Publisher) publishAvailability(d *device) {
+	if d.online {
+		p.publish(p.deviceTopic(d.serial, "availability"), "online")
+	} else {
+		p.publish(p.deviceTopic(d.serial, "availability"), "offline")
+	}
+}
+
+func (p *Publisher) publishState(d *device) {
+	state := make(map[string]float64, len(d.values))
+	for k, v := range d.values {
+		state[k] = v.v
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("error encoding state for %s: %v", d.serial, err)
+		return
+	}
+	p.publish(p.deviceTopic(d.serial, "state"), b)
+}
+
+// Docs: https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery
+type discoveryConfig struct {
+	Name              string         `json:"name"`
+	UniqueID          string         `json:"unique_id"`
+	ObjectID          string         `json:"object_id"`
+	StateTopic        string         `json:"state_topic"`
+	ValueTemplate     string         `json:"value_template"`
+	DeviceClass       string         `json:"device_class,omitempty"`
+	StateClass        string         `json:"state_class,omitempty"`
+	UnitOfMeasurement string         `json:"unit_of_measurement,omitempty"`
+	Icon              string         `json:"icon,omitempty"`
+	EntityCategory    string         `json:"entity_category,omitempty"`
+	Availability      []availability `json:"availability"`
+	AvailabilityMode  string         `json:"availability_mode"`
+	Device            deviceConfig   `json:"device"`
+}
+
+type availability struct {
+	Topic string `json:"topic"`
+}
+
+type deviceConfig struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+func (p *Publisher) announce(d *device, e entity) {
+	id := "tempest_" + strings.ToLower(strings.ReplaceAll(d.serial, "-", "_")) + "_" + e.key
+	cfg := discoveryConfig{
+		Name:              e.name,
+		UniqueID:          id,
+		ObjectID:          id,
+		StateTopic:        p.deviceTopic(d.serial, "state"),
+		ValueTemplate:     fmt.Sprintf("{{ value_json.%s }}", e.key),
+		DeviceClass:       e.deviceClass,
+		StateClass:        e.stateClass,
+		UnitOfMeasurement: e.unit,
+		Icon:              e.icon,
+		Availability: []availability{
+			{Topic: p.statusTopic()},
+			{Topic: p.deviceTopic(d.serial, "availability")},
+		},
+		AvailabilityMode: "all",
+		Device: deviceConfig{
+			Identifiers:  []string{d.serial},
+			Name:         d.serial,
+			Manufacturer: "WeatherFlow",
+			Model:        model(d.serial),
+		},
+	}
+	if e.diagnostic {
+		cfg.EntityCategory = "diagnostic"
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		log.Printf("error encoding discovery config for %s: %v", id, err)
+		return
+	}
+	p.publish(p.opts.DiscoveryPrefix+"/sensor/"+id+"/config", b)
+}
+
+func model(serial string) string {
+	switch {
+	case strings.HasPrefix(serial, "ST-"):
+		return "Tempest"
+	case strings.HasPrefix(serial, "HB-"):
+		return "Hub"
+	default:
+		return ""
+	}
+}
+
+// publish sends a retained message, without waiting for it to be delivered.
+func (p *Publisher) publish(topic string, payload interface{}) {
+	t := p.client.Publish(topic, 1, true, payload)
+	go func() {
+		if t.WaitTimeout(10*time.Second) && t.Error() != nil {
+			log.Printf("error publishing to %s: %v", topic, t.Error())
+		}
+	}()
+}
@@ -0,0 +1,205 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempestudp"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// broker is an in-process MQTT broker which accepts a single client and records the retained messages it publishes.
+type broker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	updated  chan struct{}
+}
+
+func newBroker(t *testing.T) *broker {
+	return newBrokerAt(t, "127.0.0.1:0")
+}
+
+func newBrokerAt(t *testing.T, addr string) *broker {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{ln: ln, retained: make(map[string][]byte), updated: make(chan struct{}, 1)}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *broker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var reply packets.ControlPacket
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.retained[p.TopicName] = p.Payload
+			b.mu.Unlock()
+			select {
+			case b.updated <- struct{}{}:
+			default:
+			}
+			if p.Qos == 1 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				reply = ack
+			}
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			reply = ack
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			if err := reply.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// waitFor waits until topic has been published with a payload accepted by check.
+func (b *broker) waitFor(t *testing.T, topic string, check func([]byte) bool) []byte {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		b.mu.Lock()
+		payload, ok := b.retained[topic]
+		b.mu.Unlock()
+		if ok && check(payload) {
+			return payload
+		}
+
+		select {
+		case <-b.updated:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s, last payload %q", topic, payload)
+		}
+	}
+}
+
+func equals(want string) func([]byte) bool {
+	return func(b []byte) bool { return string(b) == want }
+}
+
+func TestPublisher(t *testing.T) {
+	b := newBroker(t)
+	p := New(Options{Broker: b.url(), ExpireAfter: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	b.waitFor(t, "tempest/status", equals("online"))
+
+	report, err := tempestudp.ParseReport([]byte(`{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.00,0.49,1.44,163,3,987.81,19.00,67.63,57687,4.38,480,0.000000,0,0,0,2.792,1]],"firmware_revision":156}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Publish(report.Metrics())
+
+	b.waitFor(t, "tempest/ST-00019709/availability", equals("online"))
+	state := b.waitFor(t, "tempest/ST-00019709/state", func([]byte) bool { return true })
+	var values map[string]float64
+	if err := json.Unmarshal(state, &values); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]float64{
+		"temperature_air": 19.0,
+		"humidity":        67.63,
+		"pressure":        987.81,
+		"wind_gust":       1.44,
+	} {
+		if got := values[key]; got < want-0.001 || got > want+0.001 {
+			t.Errorf("state %s = %v, want %v", key, got, want)
+		}
+	}
+
+	config := b.waitFor(t, "homeassistant/sensor/tempest_st_00019709_temperature_air/config", func([]byte) bool { return true })
+	var cfg discoveryConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DeviceClass != "temperature" || cfg.UnitOfMeasurement != "°C" || cfg.StateTopic != "tempest/ST-00019709/state" ||
+		cfg.ValueTemplate != "{{ value_json.temperature_air }}" || cfg.Device.Model != "Tempest" || len(cfg.Availability) != 2 {
+		t.Errorf("discovery config = %s", config)
+	}
+
+	// With no further reports, the device becomes unavailable
+	b.waitFor(t, "tempest/ST-00019709/availability", equals("offline"))
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	b.waitFor(t, "tempest/status", equals("offline"))
+}
+
+func TestPublisher_brokerDown(t *testing.T) {
+	// Find a free port, then leave nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := New(Options{Broker: "tcp://" + addr, ConnectRetryInterval: 50 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	report, err := tempestudp.ParseReport([]byte(`{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.00,0.49,1.44,163,3,987.81,19.00,67.63,57687,4.38,480,0.000000,0,0,0,2.792,1]],"firmware_revision":156}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	p.Publish(report.Metrics())
+
+	// Once the broker comes up, everything is announced
+	b := newBrokerAt(t, addr)
+	b.waitFor(t, "tempest/status", equals("online"))
+	b.waitFor(t, "tempest/ST-00019709/availability", equals("online"))
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	b.waitFor(t, "tempest/status", equals("offline"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"tempest_exporter/pcap"
+	"tempest_exporter/relay"
+	"tempest_exporter/tempest"
+)
+
+func parse(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("parse", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	pipe := newPipeline(cfg)
+	err = eachPacket(fs.Args(), func(p relay.Packet) error {
+		metrics, err := pipe.metrics(cfg, p.Message, p.Time)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "error parsing %s: %v\n", p.Message, err)
+			return nil
+		}
+
+		var b []byte
+		for _, m := range metrics {
+			s, err := tempest.NewSample(m)
+			if err != nil {
+				return err
+			}
+			b = s.AppendText(b)
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d messages could not be parsed", failed)
+	}
+	return nil
+}
+
+// The port Tempest hubs broadcast to, which identifies their messages in packet captures
+const tempestPort = 50222
+
+// eachPacket calls fn with each message in the named files, or in stdin if there are none. Files may contain JSON
+// messages as broadcast, or as captured along with when and where they were received, typically one per line. They may
+// also be pcap or pcapng packet captures, from which UDP datagrams to the Tempest port are taken.
+func eachPacket(names []string, fn func(relay.Packet) error) error {
+	if len(names) == 0 {
+		return eachPacketIn(os.Stdin, fn)
+	}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = eachPacketIn(f, fn)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func eachPacketIn(r io.Reader, fn func(relay.Packet) error) error {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(4); pcap.IsCapture(header) {
+		return pcap.Read(br, tempestPort, fn)
+	}
+
+	d := json.NewDecoder(br)
+	for {
+		var msg json.RawMessage
+		if err := d.Decode(&msg); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var p relay.Packet
+		if err := json.Unmarshal(msg, &p); err != nil || len(p.Message) == 0 {
+			p = relay.Packet{Message: msg}
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
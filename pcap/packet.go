@@ -0,0 +1,202 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+// Link types, from https://www.tcpdump.org/linktypes.html
+const (
+	linkNull      = 0
+	linkEthernet  = 1
+	linkRaw       = 101
+	linkLoop      = 108
+	linkLinuxSLL  = 113
+	linkIPv4      = 228
+	linkIPv6      = 229
+	linkLinuxSLL2 = 276
+)
+
+// EtherTypes
+const (
+	etherIPv4  = 0x0800
+	etherIPv6  = 0x86dd
+	etherVLAN  = 0x8100
+	etherQinQ  = 0x88a8
+	etherQinQ2 = 0x9100
+)
+
+const protocolUDP = 17
+
+// datagram decodes a frame down to the UDP layer.
+func (d *decoder) datagram(linkType uint32, ts time.Time, frame []byte) (relay.Packet, bool) {
+	var etherType uint16
+	switch linkType {
+	case linkEthernet:
+		if len(frame) < 14 {
+			return relay.Packet{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for etherType == etherVLAN || etherType == etherQinQ || etherType == etherQinQ2 {
+			if len(frame) < 4 {
+				return relay.Packet{}, false
+			}
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkLinuxSLL:
+		if len(frame) < 16 {
+			return relay.Packet{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkLinuxSLL2:
+		if len(frame) < 20 {
+			return relay.Packet{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame), frame[20:]
+	case linkNull, linkLoop:
+		// The address family, in the capturing host's byte order
+		if len(frame) < 4 {
+			return relay.Packet{}, false
+		}
+		frame = frame[4:]
+		etherType = ipVersion(frame)
+	case linkRaw, linkIPv4, linkIPv6:
+		etherType = ipVersion(frame)
+	default:
+		return relay.Packet{}, false
+	}
+
+	var src net.IP
+	var payload []byte
+	switch etherType {
+	case etherIPv4:
+		src, payload = d.ipv4(ts, frame)
+	case etherIPv6:
+		src, payload = d.ipv6(ts, frame)
+	}
+	if payload == nil || len(payload) < 8 {
+		return relay.Packet{}, false
+	}
+
+	srcPort, dstPort := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+	if int(dstPort) != d.port {
+		return relay.Packet{}, false
+	}
+	length := int(binary.BigEndian.Uint16(payload[4:]))
+	if length < 8 || length > len(payload) {
+		// Use whatever was captured
+		length = len(payload)
+	}
+	return relay.Packet{
+		Time:    ts,
+		Source:  net.JoinHostPort(src.String(), strconv.Itoa(int(srcPort))),
+		Message: append([]byte(nil), payload[8:length]...),
+	}, true
+}
+
+func ipVersion(packet []byte) uint16 {
+	if len(packet) == 0 {
+		return 0
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		return etherIPv4
+	case 6:
+		return etherIPv6
+	}
+	return 0
+}
+
+// ipv4 returns the source address and UDP layer of an IPv4 packet, or nil if it isn't UDP or is a fragment of a
+// datagram which isn't yet complete.
+func (d *decoder) ipv4(ts time.Time, packet []byte) (net.IP, []byte) {
+	if len(packet) < 20 {
+		return nil, nil
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(packet[2:]))
+	if headerLen < 20 || total < headerLen || len(packet) < headerLen {
+		return nil, nil
+	}
+	if total > len(packet) {
+		// Truncated by the capture
+		total = len(packet)
+	}
+	if packet[9] != protocolUDP {
+		return nil, nil
+	}
+	src := net.IP(append([]byte(nil), packet[12:16]...))
+	payload := packet[headerLen:total]
+
+	flags := binary.BigEndian.Uint16(packet[6:])
+	more, offset := flags&0x2000 != 0, int(flags&0x1fff)*8
+	if !more && offset == 0 {
+		return src, payload
+	}
+	key := fragmentKey{id: uint32(binary.BigEndian.Uint16(packet[4:]))}
+	copy(key.src[:], packet[12:16])
+	copy(key.dst[:], packet[16:20])
+	return src, d.fragments.add(key, ts, offset, more, payload)
+}
+
+// IPv6 extension headers which may precede the fragment header or UDP
+const (
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6Destination = 60
+)
+
+// ipv6 returns the source address and UDP layer of an IPv6 packet, or nil if it isn't UDP or is a fragment of a
+// datagram which isn't yet complete.
+func (d *decoder) ipv6(ts time.Time, packet []byte) (net.IP, []byte) {
+	if len(packet) < 40 {
+		return nil, nil
+	}
+	src := net.IP(append([]byte(nil), packet[8:24]...))
+	next := packet[6]
+	end := 40 + int(binary.BigEndian.Uint16(packet[4:]))
+	if end > len(packet) {
+		end = len(packet)
+	}
+	rest := packet[40:end]
+
+	for {
+		switch next {
+		case protocolUDP:
+			return src, rest
+
+		case ipv6HopByHop, ipv6Routing, ipv6Destination:
+			if len(rest) < 8 {
+				return nil, nil
+			}
+			n := 8 + int(rest[1])*8
+			if n > len(rest) {
+				return nil, nil
+			}
+			next, rest = rest[0], rest[n:]
+
+		case ipv6Fragment:
+			if len(rest) < 8 {
+				return nil, nil
+			}
+			// Only UDP directly follows the fragment header in the datagrams we want
+			if rest[0] != protocolUDP {
+				return nil, nil
+			}
+			field := binary.BigEndian.Uint16(rest[2:])
+			more, offset := field&1 != 0, int(field&0xfff8)
+			key := fragmentKey{id: binary.BigEndian.Uint32(rest[4:]), v6: true}
+			copy(key.src[:], packet[8:24])
+			copy(key.dst[:], packet[24:40])
+			return src, d.fragments.add(key, ts, offset, more, rest[8:])
+
+		default:
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package pcap extracts UDP payloads from packet captures in the pcap and pcapng formats, reassembling fragmented IP
+// datagrams, without needing libpcap.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+// Magic numbers which begin each format
+const (
+	pcapMicros      = 0xa1b2c3d4
+	pcapNanos       = 0xa1b23c4d
+	pcapngSection   = 0x0a0d0d0a
+	pcapngByteOrder = 0x1a2b3c4d
+)
+
+// IsCapture returns whether a file starting with header is a packet capture, needing at least four bytes.
+func IsCapture(header []byte) bool {
+	if len(header) < 4 {
+		return false
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		switch order.Uint32(header) {
+		case pcapMicros, pcapNanos, pcapngSection:
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads a pcap or pcapng capture, calling fn with the payload of each UDP datagram sent to port, along with its
+// capture time and source address. Anything else in the capture is skipped.
+func Read(r io.Reader, port int, fn func(relay.Packet) error) error {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(4)
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{port: port, fn: fn, fragments: newReassembler()}
+	if binary.LittleEndian.Uint32(header) == pcapngSection {
+		return d.readPcapng(br)
+	}
+	return d.readPcap(br)
+}
+
+var errFormat = errors.New("not a pcap or pcapng capture")
+
+// decoder decodes frames from either format.
+type decoder struct {
+	port      int
+	fn        func(relay.Packet) error
+	fragments *reassembler
+}
+
+func (d *decoder) readPcap(r io.Reader) error {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	var resolution time.Duration
+	for _, o := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch o.Uint32(header[:]) {
+		case pcapMicros:
+			order, resolution = o, time.Microsecond
+		case pcapNanos:
+			order, resolution = o, time.Nanosecond
+		}
+	}
+	if order == nil {
+		return errFormat
+	}
+	// The upper bits may hold the FCS length
+	linkType := order.Uint32(header[20:]) & 0x0fffffff
+
+	var record [16]byte
+	for {
+		if _, err := io.ReadFull(r, record[:]); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ts := time.Unix(int64(order.Uint32(record[0:])), 0).Add(time.Duration(order.Uint32(record[4:])) * resolution)
+		frame := make([]byte, order.Uint32(record[8:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return err
+		}
+		if err := d.frame(linkType, ts, frame); err != nil {
+			return err
+		}
+	}
+}
+
+// pcapng block types
+const (
+	blockInterface      = 0x00000001
+	blockPacket         = 0x00000002
+	blockSimplePacket   = 0x00000003
+	blockEnhancedPacket = 0x00000006
+)
+
+type iface struct {
+	linkType uint16
+
+	// Timestamps are in units of 1/resolution seconds
+	resolution uint64
+}
+
+func (d *decoder) readPcapng(r io.Reader) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []iface
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		typ := binary.LittleEndian.Uint32(head[:])
+		if typ == pcapngSection {
+			// The byte order magic follows the length, so peek at it before trusting the length
+			var magic [4]byte
+			if _, err := io.ReadFull(r, magic[:]); err != nil {
+				return err
+			}
+			switch {
+			case binary.LittleEndian.Uint32(magic[:]) == pcapngByteOrder:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(magic[:]) == pcapngByteOrder:
+				order = binary.BigEndian
+			default:
+				return errFormat
+			}
+			length := order.Uint32(head[4:])
+			if length < 16 || length%4 != 0 {
+				return fmt.Errorf("invalid section header length %d", length)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(length)-12); err != nil {
+				return err
+			}
+			interfaces = nil
+			continue
+		}
+
+		typ = order.Uint32(head[:])
+		length := order.Uint32(head[4:])
+		if length < 12 || length%4 != 0 {
+			return fmt.Errorf("invalid block length %d", length)
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		body = body[:len(body)-4]
+
+		switch typ {
+		case blockInterface:
+			if len(body) < 8 {
+				return errors.New("short interface description block")
+			}
+			interfaces = append(interfaces, iface{
+				linkType:   order.Uint16(body),
+				resolution: tsResolution(order, body[8:]),
+			})
+
+		case blockEnhancedPacket, blockPacket:
+			if len(body) < 20 {
+				return errors.New("short packet block")
+			}
+			var id uint32
+			if typ == blockEnhancedPacket {
+				id = order.Uint32(body)
+			} else {
+				id = uint32(order.Uint16(body))
+			}
+			if int(id) >= len(interfaces) {
+				return fmt.Errorf("packet on undescribed interface %d", id)
+			}
+			ifc := interfaces[id]
+			ticks := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			captured := order.Uint32(body[12:])
+			if int(captured) > len(body)-20 {
+				return errors.New("packet block overruns its length")
+			}
+			ts := time.Unix(int64(ticks/ifc.resolution), int64(ticks%ifc.resolution*uint64(time.Second)/ifc.resolution))
+			if err := d.frame(uint32(ifc.linkType), ts, body[20:20+captured]); err != nil {
+				return err
+			}
+
+		case blockSimplePacket:
+			// No timestamp, and always on the first interface
+			if len(interfaces) == 0 || len(body) < 4 {
+				continue
+			}
+			captured := order.Uint32(body)
+			if int(captured) > len(body)-4 {
+				captured = uint32(len(body) - 4)
+			}
+			if err := d.frame(uint32(interfaces[0].linkType), time.Time{}, body[4:4+captured]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// tsResolution finds the if_tsresol option, defaulting to microseconds.
+func tsResolution(order binary.ByteOrder, options []byte) uint64 {
+	for len(options) >= 4 {
+		code, length := order.Uint16(options), int(order.Uint16(options[2:]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == 9 && length == 1 {
+			v := options[4]
+			if v&0x80 != 0 {
+				return 1 << (v & 0x7f)
+			}
+			resolution := uint64(1)
+			for i := byte(0); i < v; i++ {
+				resolution *= 10
+			}
+			return resolution
+		}
+		options = options[4+(length+3)&^3:]
+	}
+	return 1_000_000
+}
+
+// frame decodes a captured frame, passing on a complete UDP datagram to the port, if there is one.
+func (d *decoder) frame(linkType uint32, ts time.Time, frame []byte) error {
+	p, ok := d.datagram(linkType, ts, frame)
+	if !ok {
+		return nil
+	}
+	return d.fn(p)
+}
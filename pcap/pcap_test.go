@@ -0,0 +1,246 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+const message = `{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.18,0.63,1.29,105,3,1014.72,19.5,71,0,0,0,0,0,0,0,2.63,1]],"firmware_revision":171}`
+
+func udp(srcPort, dstPort uint16, payload string) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(b, srcPort)
+	binary.BigEndian.PutUint16(b[2:], dstPort)
+	binary.BigEndian.PutUint16(b[4:], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+// ipv4Fragments splits a UDP datagram into IPv4 packets carrying at most size bytes each.
+func ipv4Fragments(src, dst net.IP, datagram []byte, size int) [][]byte {
+	var packets [][]byte
+	for offset := 0; offset < len(datagram); offset += size {
+		end := offset + size
+		more := end < len(datagram)
+		if !more {
+			end = len(datagram)
+		}
+		h := make([]byte, 20)
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:], uint16(20+end-offset))
+		binary.BigEndian.PutUint16(h[4:], 0x1234)
+		flags := uint16(offset / 8)
+		if more {
+			flags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(h[6:], flags)
+		h[8] = 64
+		h[9] = protocolUDP
+		copy(h[12:], src.To4())
+		copy(h[16:], dst.To4())
+		packets = append(packets, append(h, datagram[offset:end]...))
+	}
+	return packets
+}
+
+// ipv6Fragments splits a UDP datagram into IPv6 packets carrying at most size bytes each.
+func ipv6Fragments(src, dst net.IP, datagram []byte, size int) [][]byte {
+	var packets [][]byte
+	for offset := 0; offset < len(datagram); offset += size {
+		end := offset + size
+		more := end < len(datagram)
+		if !more {
+			end = len(datagram)
+		}
+		h := make([]byte, 48)
+		h[0] = 0x60
+		binary.BigEndian.PutUint16(h[4:], uint16(8+end-offset))
+		h[6] = ipv6Fragment
+		h[7] = 64
+		copy(h[8:], src)
+		copy(h[24:], dst)
+		h[40] = protocolUDP
+		field := uint16(offset)
+		if more {
+			field |= 1
+		}
+		binary.BigEndian.PutUint16(h[42:], field)
+		binary.BigEndian.PutUint32(h[44:], 0xcafe)
+		packets = append(packets, append(h, datagram[offset:end]...))
+	}
+	return packets
+}
+
+func ethernetVLAN(packet []byte) []byte {
+	f := make([]byte, 18)
+	copy(f, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	binary.BigEndian.PutUint16(f[12:], etherVLAN)
+	binary.BigEndian.PutUint16(f[14:], 20)
+	binary.BigEndian.PutUint16(f[16:], etherIPv4)
+	return append(f, packet...)
+}
+
+func linuxSLL2(packet []byte) []byte {
+	f := make([]byte, 20)
+	binary.BigEndian.PutUint16(f, etherIPv6)
+	return append(f, packet...)
+}
+
+func TestRead_pcap(t *testing.T) {
+	src := net.IPv4(192, 168, 20, 50)
+	start := time.Date(2023, 7, 6, 18, 39, 1, 250000000, time.UTC)
+
+	var frames [][]byte
+	// Something else on the network
+	frames = append(frames, ethernetVLAN(ipv4Fragments(src, net.IPv4bcast, udp(50222, 53, "not for us"), 1480)[0]))
+	// Fragments, out of order
+	fragments := ipv4Fragments(src, net.IPv4bcast, udp(50222, 50222, message), 64)
+	frames = append(frames, ethernetVLAN(fragments[1]))
+	frames = append(frames, ethernetVLAN(fragments[0]))
+	for _, f := range fragments[2:] {
+		frames = append(frames, ethernetVLAN(f))
+	}
+
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	header := make([]byte, 24)
+	le.PutUint32(header, pcapMicros)
+	le.PutUint16(header[4:], 2)
+	le.PutUint16(header[6:], 4)
+	le.PutUint32(header[16:], 65535)
+	le.PutUint32(header[20:], linkEthernet)
+	b.Write(header)
+	for i, f := range frames {
+		ts := start.Add(time.Duration(i) * time.Millisecond)
+		record := make([]byte, 16)
+		le.PutUint32(record, uint32(ts.Unix()))
+		le.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+		le.PutUint32(record[8:], uint32(len(f)))
+		le.PutUint32(record[12:], uint32(len(f)))
+		b.Write(record)
+		b.Write(f)
+	}
+
+	if !IsCapture(b.Bytes()) {
+		t.Error("IsCapture() = false")
+	}
+	got := readAll(t, &b)
+	want := []relay.Packet{{
+		Time:    start.Add(time.Duration(len(frames)-1) * time.Millisecond),
+		Source:  "192.168.20.50:50222",
+		Message: []byte(message),
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRead_pcapng(t *testing.T) {
+	src := net.ParseIP("fe80::1:2")
+	dst := net.ParseIP("ff02::1")
+	ts := time.Date(2023, 7, 6, 18, 39, 1, 123456789, time.UTC)
+
+	var b bytes.Buffer
+	be := binary.BigEndian
+	block := func(typ uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		length := uint32(12 + len(body))
+		h := make([]byte, 8)
+		be.PutUint32(h, typ)
+		be.PutUint32(h[4:], length)
+		b.Write(h)
+		b.Write(body)
+		be.PutUint32(h, length)
+		b.Write(h[:4])
+	}
+
+	shb := make([]byte, 16)
+	be.PutUint32(shb, pcapngByteOrder)
+	be.PutUint16(shb[4:], 1)
+	be.PutUint64(shb[8:], ^uint64(0))
+	block(pcapngSection, shb)
+
+	// Nanosecond timestamps
+	idb := make([]byte, 8, 20)
+	be.PutUint16(idb, linkLinuxSLL2)
+	idb = append(idb, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0)
+	block(blockInterface, idb)
+
+	for _, packet := range ipv6Fragments(src, dst, udp(50222, 50222, message), 96) {
+		frame := linuxSLL2(packet)
+		epb := make([]byte, 20)
+		ticks := uint64(ts.UnixNano())
+		be.PutUint32(epb[4:], uint32(ticks>>32))
+		be.PutUint32(epb[8:], uint32(ticks))
+		be.PutUint32(epb[12:], uint32(len(frame)))
+		be.PutUint32(epb[16:], uint32(len(frame)))
+		block(blockEnhancedPacket, append(epb, frame...))
+	}
+
+	if !IsCapture(b.Bytes()) {
+		t.Error("IsCapture() = false")
+	}
+	got := readAll(t, &b)
+	want := []relay.Packet{{Time: ts, Source: "[fe80::1:2]:50222", Message: []byte(message)}}
+	if len(got) != 1 || !got[0].Time.Equal(want[0].Time) || got[0].Source != want[0].Source || string(got[0].Message) != message {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func Test_reassembler_malformed(t *testing.T) {
+	ts := time.Date(2023, 7, 6, 18, 39, 1, 0, time.UTC)
+	fragments := []struct {
+		offset, length int
+		more           bool
+	}{
+		{0, 32, true},
+		{24, 8, true},
+		// Ends the datagram before the fragments already seen
+		{8, 8, false},
+		// Contradicts the length of the last fragment
+		{16, 16, false},
+	}
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"past the end", []int{0, 1, 2}},
+		{"last fragment first", []int{2, 0}},
+		{"two last fragments", []int{3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler()
+			key := fragmentKey{id: 1}
+			for _, i := range tt.order {
+				f := fragments[i]
+				if got := r.add(key, ts, f.offset, f.more, make([]byte, f.length)); got != nil {
+					t.Errorf("add(%d, %d) = %d bytes, want none", f.offset, f.length, len(got))
+				}
+			}
+			if len(r.partials) != 0 {
+				t.Errorf("%d datagrams still pending", len(r.partials))
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, b *bytes.Buffer) []relay.Packet {
+	t.Helper()
+	var packets []relay.Packet
+	if err := Read(b, 50222, func(p relay.Packet) error {
+		p.Time = p.Time.UTC()
+		packets = append(packets, p)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return packets
+}
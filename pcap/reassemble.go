@@ -0,0 +1,107 @@
+package pcap
+
+import (
+	"sort"
+	"time"
+)
+
+// Limits on reassembly, so that lost fragments don't accumulate
+const (
+	fragmentTimeout = 30 * time.Second
+	maxDatagrams    = 1024
+	maxDatagramSize = 65535
+)
+
+type fragmentKey struct {
+	src, dst [16]byte
+	id       uint32
+	v6       bool
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+type partial struct {
+	first     time.Time
+	fragments []fragment
+
+	// The length of the whole datagram, once its last fragment has arrived
+	length int
+}
+
+// reassembler puts fragmented IP datagrams back together.
+type reassembler struct {
+	partials map[fragmentKey]*partial
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{partials: make(map[fragmentKey]*partial)}
+}
+
+// add adds a fragment, returning the reassembled payload once every fragment has arrived.
+func (r *reassembler) add(key fragmentKey, ts time.Time, offset int, more bool, data []byte) []byte {
+	r.expire(ts)
+
+	p, ok := r.partials[key]
+	if !ok {
+		if len(r.partials) >= maxDatagrams {
+			return nil
+		}
+		p = &partial{first: ts, length: -1}
+		r.partials[key] = p
+	}
+	if offset+len(data) > maxDatagramSize {
+		delete(r.partials, key)
+		return nil
+	}
+	p.fragments = append(p.fragments, fragment{offset: offset, data: append([]byte(nil), data...)})
+	if !more {
+		if p.length >= 0 && p.length != offset+len(data) {
+			delete(r.partials, key)
+			return nil
+		}
+		p.length = offset + len(data)
+	}
+	if p.length < 0 {
+		return nil
+	}
+
+	// Check the fragments cover the whole datagram, tolerating overlaps and duplicates. A fragment past the end makes
+	// the whole datagram invalid.
+	sort.Slice(p.fragments, func(i, j int) bool { return p.fragments[i].offset < p.fragments[j].offset })
+	covered := 0
+	for _, f := range p.fragments {
+		end := f.offset + len(f.data)
+		if end > p.length {
+			delete(r.partials, key)
+			return nil
+		}
+		if f.offset > covered {
+			return nil
+		}
+		if end > covered {
+			covered = end
+		}
+	}
+	if covered < p.length {
+		return nil
+	}
+
+	datagram := make([]byte, p.length)
+	for _, f := range p.fragments {
+		copy(datagram[f.offset:], f.data)
+	}
+	delete(r.partials, key)
+	return datagram
+}
+
+// expire discards datagrams which have been incomplete for too long, as of the capture time ts.
+func (r *reassembler) expire(ts time.Time) {
+	for key, p := range r.partials {
+		if ts.Sub(p.first) > fragmentTimeout {
+			delete(r.partials, key)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"tempest_exporter/battery"
+	"tempest_exporter/clock"
+	"tempest_exporter/config"
+	"tempest_exporter/qc"
+	"tempest_exporter/tempestudp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pipeline turns messages into metrics in the same way for every command, remembering what the checks and derived
+// metrics need from earlier messages.
+type pipeline struct {
+	checker   *qc.Checker
+	batteries *battery.Tracker
+}
+
+func newPipeline(cfg *config.Config) *pipeline {
+	return &pipeline{checker: qc.New(cfg.QC.Options()), batteries: battery.New()}
+}
+
+// metrics parses a message and returns its metrics: compared with when it was received, if known, then quality
+// checked, then with battery health added.
+func (p *pipeline) metrics(cfg *config.Config, msg []byte, received time.Time) ([]prometheus.Metric, error) {
+	report, err := tempestudp.ParseReportWithOptions(msg, cfg.ReportOptions)
+	if err != nil {
+		return nil, err
+	}
+	metrics := report.Metrics()
+	if !received.IsZero() {
+		metrics = clock.Check(metrics, received, cfg.Clock.Options())
+	}
+	metrics = p.checker.Check(metrics)
+	if cfg.Derived.Battery {
+		metrics = p.batteries.Observe(metrics)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,257 @@
+// Package qc checks readings for values which can't be right: out of range, changing implausibly quickly, or stuck.
+package qc
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Checks which a reading can fail
+const (
+	Range = "range"
+	Step  = "step"
+	Flat  = "flat"
+)
+
+// What to do with readings which fail a check
+const (
+	// Leave out the reading, along with anything derived from it
+	Drop = "drop"
+
+	// Keep the reading, with tempest.QualityLabel naming the check it failed
+	Flag = "flag"
+)
+
+// Kinds lists the kinds of reading which can be checked.
+var Kinds = []string{
+	"temperature_air", "humidity", "pressure", "wind_lull", "wind_avg", "wind_gust", "wind_rapid",
+	"illuminance", "uv", "irradiance", "rain_rate", "battery",
+}
+
+// Thresholds sets the checks for one kind of reading, in the units of its metric. Zero values skip a check.
+type Thresholds struct {
+	// The lowest and highest plausible values
+	Min *float64
+	Max *float64
+
+	// The largest plausible change between readings a minute apart, allowing proportionally more for longer gaps
+	MaxStep float64
+
+	// The longest a reading can plausibly stay exactly the same
+	MaxFlat time.Duration
+}
+
+// Options configures the checks. With no action, nothing is checked.
+type Options struct {
+	Action string
+
+	// Thresholds by kind of reading, as in Kind
+	Kinds map[string]Thresholds
+}
+
+// Readings further apart than this aren't compared for a step change
+const maxStepGap = time.Hour
+
+// Checker checks readings against the thresholds, remembering recent readings from each device to compare against.
+type Checker struct {
+	mu       sync.Mutex
+	opts     Options
+	history  map[series]*history
+	failures map[failure]uint64
+}
+
+type series struct {
+	instance string
+	kind     string
+}
+
+type history struct {
+	// The last reading which passed, and when
+	good   float64
+	goodAt time.Time
+
+	// The last reading, and when it took that value
+	last      float64
+	flatSince time.Time
+}
+
+type failure struct {
+	kind  string
+	check string
+}
+
+func New(opts Options) *Checker {
+	return &Checker{opts: opts, history: make(map[series]*history), failures: make(map[failure]uint64)}
+}
+
+// SetOptions changes the checks, keeping what has been learned about recent readings.
+func (c *Checker) SetOptions(opts Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts
+}
+
+// Kind names the reading a sample holds, or returns "" if it isn't checked.
+func Kind(s tempest.Sample) string {
+	switch s.Family.Desc {
+	case tempest.Temperature:
+		if kind := s.Label("kind"); kind == "air" {
+			return "temperature_air"
+		}
+	case tempest.Wind:
+		return "wind_" + s.Label("kind")
+	case tempest.Humidity:
+		return "humidity"
+	case tempest.Pressure:
+		return "pressure"
+	case tempest.Illuminance:
+		return "illuminance"
+	case tempest.UV:
+		return "uv"
+	case tempest.Irradiance:
+		return "irradiance"
+	case tempest.RainRate:
+		return "rain_rate"
+	case tempest.Battery:
+		return "battery"
+	}
+	return ""
+}
+
+// dependencies returns the readings a derived sample was calculated from.
+func dependencies(s tempest.Sample) []string {
+	switch {
+	case s.Family.Desc == tempest.Temperature && s.Label("kind") == "wetbulb":
+		return []string{"temperature_air", "humidity", "pressure"}
+	case s.Family.Desc == tempest.SeaLevelPressure:
+		return []string{"pressure", "temperature_air"}
+	}
+	return nil
+}
+
+// Check checks the readings among metrics, returning the metrics to send on.
+func (c *Checker) Check(metrics []prometheus.Metric) []prometheus.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.Action == "" {
+		return metrics
+	}
+
+	// Readings which failed, by device and time, so that anything derived from them can be dropped too
+	type reading struct {
+		instance    string
+		timestampMs int64
+		kind        string
+	}
+	failed := make(map[reading]bool)
+
+	samples := make([]tempest.Sample, len(metrics))
+	checks := make([]string, len(metrics))
+	for i, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			continue
+		}
+		samples[i] = s
+
+		kind := Kind(s)
+		t, ok := c.opts.Kinds[kind]
+		if kind == "" || !ok {
+			continue
+		}
+		instance := s.Label("instance")
+		check := c.check(series{instance, kind}, t, s.Value, time.UnixMilli(s.TimestampMs))
+		if check == "" {
+			continue
+		}
+		c.failures[failure{kind, check}]++
+		failed[reading{instance, s.TimestampMs, kind}] = true
+		log.Printf("%s %s reading of %g failed the %s check", instance, kind, s.Value, check)
+		checks[i] = check
+	}
+
+	out := make([]prometheus.Metric, 0, len(metrics))
+	for i, m := range metrics {
+		s := samples[i]
+		if checks[i] != "" && c.opts.Action == Flag {
+			m = tempest.Flag(m, checks[i])
+		}
+		if s.Family != nil && c.opts.Action == Drop {
+			instance := s.Label("instance")
+			drop := failed[reading{instance, s.TimestampMs, Kind(s)}]
+			for _, kind := range dependencies(s) {
+				drop = drop || failed[reading{instance, s.TimestampMs, kind}]
+			}
+			if drop {
+				continue
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// check returns the check which a reading fails, or "" if it passes.
+func (c *Checker) check(key series, t Thresholds, v float64, at time.Time) string {
+	if t.Min != nil && v < *t.Min || t.Max != nil && v > *t.Max || math.IsNaN(v) {
+		return Range
+	}
+
+	h, ok := c.history[key]
+	if !ok {
+		c.history[key] = &history{good: v, goodAt: at, last: v, flatSince: at}
+		return ""
+	}
+	// Readings replayed or arriving out of order aren't compared
+	if at.Before(h.goodAt) {
+		return ""
+	}
+
+	if v != h.last {
+		h.last, h.flatSince = v, at
+	}
+	if gap := at.Sub(h.goodAt); t.MaxStep > 0 && gap <= maxStepGap {
+		allowed := t.MaxStep * math.Max(1, gap.Minutes())
+		if math.Abs(v-h.good) > allowed {
+			return Step
+		}
+	}
+	h.good, h.goodAt = v, at
+	if t.MaxFlat > 0 && at.Sub(h.flatSince) > t.MaxFlat {
+		return Flat
+	}
+	return ""
+}
+
+// Failures returns tempest_exporter_qc_failures_total for each kind of reading and check which has failed.
+func (c *Checker) Failures(now time.Time) []prometheus.Metric {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]failure, 0, len(c.failures))
+	for f := range c.failures {
+		keys = append(keys, f)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].check < keys[j].check
+	})
+
+	out := make([]prometheus.Metric, 0, len(keys))
+	for _, f := range keys {
+		m := prometheus.MustNewConstMetric(tempest.QCFailures, prometheus.CounterValue, float64(c.failures[f]), f.kind, f.check)
+		out = append(out, prometheus.NewMetricWithTimestamp(now, m))
+	}
+	return out
+}
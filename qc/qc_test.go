@@ -0,0 +1,112 @@
+package qc
+
+import (
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func between(min, max float64) Thresholds {
+	return Thresholds{Min: &min, Max: &max}
+}
+
+// observation returns metrics as from an obs_st report.
+func observation(ts time.Time, temperature, humidity, pressure float64) []prometheus.Metric {
+	return []prometheus.Metric{
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, temperature, "ST-00019709", "air")),
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, temperature-3, "ST-00019709", "wetbulb")),
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, humidity, "ST-00019709")),
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Pressure, prometheus.GaugeValue, pressure, "ST-00019709")),
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.SeaLevelPressure, prometheus.GaugeValue, pressure+1400, "ST-00019709")),
+		prometheus.NewMetricWithTimestamp(ts, prometheus.MustNewConstMetric(tempest.Illuminance, prometheus.GaugeValue, 57687, "ST-00019709")),
+	}
+}
+
+// seriesOf returns the series among metrics, as name/kind/check.
+func seriesOf(t *testing.T, metrics []prometheus.Metric) map[string]bool {
+	out := make(map[string]bool)
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := s.Family.Name
+		for _, name := range []string{"kind", "check", tempest.QualityLabel} {
+			if v := s.Label(name); v != "" {
+				key += "/" + v
+			}
+		}
+		out[key] = true
+	}
+	return out
+}
+
+func TestChecker_drop(t *testing.T) {
+	humidity := between(0, 100)
+	pressure := between(85000, 108500)
+	pressure.MaxStep = 300
+	c := New(Options{Action: Drop, Kinds: map[string]Thresholds{"humidity": humidity, "pressure": pressure}})
+	start := time.Unix(1688668741, 0)
+
+	if got := c.Check(observation(start, 19, 67.63, 98781)); len(got) != 6 {
+		t.Errorf("kept %d of 6 plausible metrics", len(got))
+	}
+
+	// Humidity out of range drops wet bulb along with it
+	got := seriesOf(t, c.Check(observation(start.Add(time.Minute), 19, 104, 98790)))
+	if got["tempest_humidity_percent"] || got["tempest_temperature_c/wetbulb"] {
+		t.Errorf("kept %v", got)
+	}
+	if !got["tempest_temperature_c/air"] || !got["tempest_sea_level_pressure_pa"] {
+		t.Errorf("dropped too much, kept %v", got)
+	}
+
+	// A 50 hPa jump in a minute drops pressure and everything derived from it
+	got = seriesOf(t, c.Check(observation(start.Add(2*time.Minute), 19, 67, 103790)))
+	if got["tempest_pressure_pa"] || got["tempest_sea_level_pressure_pa"] || got["tempest_temperature_c/wetbulb"] {
+		t.Errorf("kept %v", got)
+	}
+
+	// Back to normal, compared with the last good reading
+	if got := c.Check(observation(start.Add(3*time.Minute), 19, 67, 98800)); len(got) != 6 {
+		t.Errorf("kept %d of 6 plausible metrics", len(got))
+	}
+
+	failures := seriesOf(t, c.Failures(time.Now()))
+	if len(failures) != 2 || !failures["tempest_exporter_qc_failures_total/humidity/range"] || !failures["tempest_exporter_qc_failures_total/pressure/step"] {
+		t.Errorf("failures = %v", failures)
+	}
+}
+
+func TestChecker_flag(t *testing.T) {
+	temperature := Thresholds{MaxFlat: 30 * time.Minute}
+	c := New(Options{Action: Flag, Kinds: map[string]Thresholds{"temperature_air": temperature}})
+	start := time.Unix(1688668741, 0)
+
+	var got map[string]bool
+	for i := 0; i <= 40; i++ {
+		got = seriesOf(t, c.Check(observation(start.Add(time.Duration(i)*time.Minute), 19, 67, 98781)))
+		if flagged := got["tempest_temperature_c/air/flat"]; flagged != (i > 30) || got["tempest_temperature_c/air"] == flagged {
+			t.Fatalf("after %d minutes, flagged = %v: %v", i, flagged, got)
+		}
+	}
+	if !got["tempest_temperature_c/wetbulb"] || !got["tempest_humidity_percent"] {
+		t.Errorf("readings were dropped: %v", got)
+	}
+
+	// A change ends the flat line
+	got = seriesOf(t, c.Check(observation(start.Add(41*time.Minute), 19.1, 67, 98781)))
+	if got["tempest_temperature_c/air/flat"] || !got["tempest_temperature_c/air"] {
+		t.Errorf("still flagged after changing: %v", got)
+	}
+}
+
+func TestChecker_noAction(t *testing.T) {
+	c := New(Options{Kinds: map[string]Thresholds{"humidity": between(0, 100)}})
+	if got := c.Check(observation(time.Unix(1688668741, 0), 19, 104, 98781)); len(got) != 6 {
+		t.Errorf("kept %d of 6 metrics without an action", len(got))
+	}
+}
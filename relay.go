@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/config"
+	"tempest_exporter/relay"
+)
+
+var errNoRelayTargets = configError{errors.New("relay.targets: at least one target is required")}
+
+func relayCommand(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("relay", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Relay.Targets) == 0 {
+		return errNoRelayTargets
+	}
+
+	sources, err := allowlist.New(cfg.Sources.Options())
+	if err != nil {
+		return configError{err}
+	}
+
+	forwarders, streams, err := relayTargets(cfg.Relay)
+	defer func() {
+		for _, f := range forwarders {
+			if u, ok := f.(*relay.UDP); ok {
+				u.Close()
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Stop the streams however listening ends, before waiting for them
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, s := range streams {
+		wg.Add(1)
+		go func(ctx context.Context, s *relay.Stream) {
+			defer wg.Done()
+			s.Run(ctx)
+		}(ctx, s)
+	}
+
+	return listen(ctx, cfg.Listen, func(b []byte, addr *net.UDPAddr) error {
+		if !json.Valid(b) {
+			log.Printf("not relaying a malformed message from %s: %q", addr, b)
+			return nil
+		}
+		p := relay.NewPacket(b, addr)
+		if reason := sources.Check(p); reason != "" {
+			log.Printf("not relaying a message from %s rejected by the %s rules: %s", addr, reason, b)
+			return nil
+		}
+		for _, f := range forwarders {
+			f.Forward(p)
+		}
+		return nil
+	})
+}
+
+// relayTargets returns a forwarder for each target, along with the streams among them which need to run.
+func relayTargets(cfg config.Relay) ([]relay.Forwarder, []*relay.Stream, error) {
+	var forwarders []relay.Forwarder
+	var streams []*relay.Stream
+	for _, target := range cfg.Targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return forwarders, streams, configError{err}
+		}
+		switch u.Scheme {
+		case "udp":
+			f, err := relay.NewUDP(u.Host)
+			if err != nil {
+				return forwarders, streams, fmt.Errorf("relay to %s: %w", target, err)
+			}
+			forwarders = append(forwarders, f)
+			log.Printf("relaying to %s", u.Host)
+		default:
+			s := relay.NewStream(relay.StreamOptions{URL: target, Token: cfg.Token, Compress: cfg.Compress})
+			forwarders = append(forwarders, s)
+			streams = append(streams, s)
+			log.Printf("relaying to %s", target)
+		}
+	}
+	return forwarders, streams, nil
+}
@@ -0,0 +1,43 @@
+// Package relay carries Tempest UDP messages across network boundaries, since the hub only broadcasts on its own
+// network.
+package relay
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// Packet is a message as received from a hub, along with when and from where.
+type Packet struct {
+	Time    time.Time       `json:"time"`
+	Source  string          `json:"source"`
+	Message json.RawMessage `json:"message"`
+}
+
+// NewPacket returns a packet for a message received now from addr. The message is copied, so the caller may reuse its
+// buffer.
+func NewPacket(b []byte, addr *net.UDPAddr) Packet {
+	p := Packet{
+		Time:    time.Now(),
+		Message: append(json.RawMessage(nil), b...),
+	}
+	if addr != nil {
+		p.Source = addr.String()
+	}
+	return p
+}
+
+// Addr returns the address the packet was originally received from, or nil if it is not known.
+func (p Packet) Addr() *net.UDPAddr {
+	addr, err := net.ResolveUDPAddr("udp", p.Source)
+	if err != nil || addr.IP == nil {
+		return nil
+	}
+	return addr
+}
+
+// Forwarder sends packets elsewhere. Forward must not block.
+type Forwarder interface {
+	Forward(p Packet)
+}
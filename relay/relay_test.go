@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const message = `{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}`
+
+func TestStream(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			received := make(chan Packet, 10)
+			srv := httptest.NewServer(Handler("s3cret", func(p Packet) { received <- p }))
+			defer srv.Close()
+
+			s := NewStream(StreamOptions{URL: srv.URL, Token: "s3cret", Compress: compress})
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				s.Run(ctx)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			source := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 50), Port: 50222}
+			for i := 0; i < 3; i++ {
+				s.Forward(NewPacket([]byte(message), source))
+			}
+
+			for i := 0; i < 3; i++ {
+				select {
+				case p := <-received:
+					if string(p.Message) != message {
+						t.Errorf("Message = %s", p.Message)
+					}
+					if addr := p.Addr(); addr == nil || addr.String() != source.String() {
+						t.Errorf("Addr() = %v, want %v", addr, source)
+					}
+					if time.Since(p.Time) > time.Minute {
+						t.Errorf("Time = %v", p.Time)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("received %d packets, want 3", i)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_unauthorized(t *testing.T) {
+	var mu sync.Mutex
+	var received int
+	srv := httptest.NewServer(Handler("s3cret", func(p Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		received++
+	}))
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"source":"192.168.1.50:50222","message":{}}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d, want 401", token, resp.StatusCode)
+		}
+	}
+	if received != 0 {
+		t.Errorf("received %d packets without authorization", received)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	u, err := NewUDP(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	u.Forward(NewPacket([]byte(message), nil))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 1500)
+	n, _, err := conn.ReadFromUDP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != message {
+		t.Errorf("received %s", b[:n])
+	}
+}
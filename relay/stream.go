@@ -0,0 +1,163 @@
+package relay
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// StreamOptions configures a Stream.
+type StreamOptions struct {
+	// The URL of the receiving exporter's relay endpoint
+	URL string
+
+	// The bearer token the receiver expects
+	Token string
+
+	// Whether to gzip the stream
+	Compress bool
+
+	// How many packets to hold while disconnected, defaulting to 1000
+	Capacity int
+}
+
+// Stream forwards packets to another exporter over a long-lived HTTP request, as newline-delimited JSON. Each packet
+// keeps its receive time and source address. If the connection fails, Stream reconnects with increasing delays,
+// holding packets meanwhile until its buffer is full.
+type Stream struct {
+	opts    StreamOptions
+	client  *http.Client
+	packets chan Packet
+	dropped atomic.Int64
+}
+
+func NewStream(opts StreamOptions) *Stream {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1000
+	}
+	return &Stream{
+		opts:    opts,
+		client:  &http.Client{},
+		packets: make(chan Packet, opts.Capacity),
+	}
+}
+
+// Forward queues a packet, dropping it if the buffer is full.
+func (s *Stream) Forward(p Packet) {
+	select {
+	case s.packets <- p:
+	default:
+		if s.dropped.Add(1) == 1 {
+			log.Printf("relay to %s: buffer full, dropping packets", s.opts.URL)
+		}
+	}
+}
+
+// Dropped returns the number of packets dropped because the buffer was full.
+func (s *Stream) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Run streams packets until ctx is done.
+func (s *Stream) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// A connection which lasted a while deserves a quick retry
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Printf("relay to %s: %v; reconnecting in %s", s.opts.URL, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// stream makes one request, writing packets to it until it fails or ctx is done.
+func (s *Stream) stream(ctx context.Context) error {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	}
+
+	var w io.Writer = pw
+	var gz *gzip.Writer
+	if s.opts.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+		gz = gzip.NewWriter(pw)
+		w = gz
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := s.client.Do(req)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			result <- fmt.Errorf("%s", resp.Status)
+		} else {
+			result <- fmt.Errorf("closed by the receiver")
+		}
+	}()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case p := <-s.packets:
+			err := enc.Encode(p)
+			if err == nil && gz != nil {
+				// Send each packet promptly, rather than waiting for a full block
+				err = gz.Flush()
+			}
+			if err != nil {
+				// The request has failed, so find out why
+				pw.CloseWithError(err)
+				return <-result
+			}
+
+		case err := <-result:
+			return err
+
+		case <-ctx.Done():
+			if gz != nil {
+				gz.Close()
+			}
+			pw.Close()
+			<-result
+			return nil
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package relay
+
+import (
+	"log"
+	"net"
+)
+
+// UDP forwards each message unchanged as a unicast datagram, so that anything which listens for Tempest broadcasts
+// can receive it. The message itself identifies the hub and device, but the hub's address is replaced by ours.
+type UDP struct {
+	conn net.Conn
+}
+
+func NewUDP(addr string) (*UDP, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDP{conn: conn}, nil
+}
+
+func (u *UDP) Forward(p Packet) {
+	if _, err := u.conn.Write(p.Message); err != nil {
+		log.Printf("relay to %s: %v", u.conn.RemoteAddr(), err)
+	}
+}
+
+func (u *UDP) Close() error {
+	return u.conn.Close()
+}
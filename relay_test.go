@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/relay"
+)
+
+func Test_relayHandler(t *testing.T) {
+	// Only the hub's LAN is allowed, but the test client connects from loopback
+	sources, err := allowlist.New(allowlist.Options{Addresses: allowlist.Rules{Include: []string{"192.168.1.0/24"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allowed atomic.Pointer[allowlist.List]
+	allowed.Store(sources)
+
+	const body = `{"time":"2023-07-06T18:36:12.5Z","source":"192.168.1.10:50222","message":{"serial_number":"ST-00019709","type":"rapid_wind","hub_sn":"HB-00031344","ob":[1688668572,0.85,113]}}` + "\n"
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		received   int
+	}{
+		{"claimed address without a token", "", http.StatusForbidden, 0},
+		{"trusted relay with a token", "s3cret", http.StatusNoContent, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			self := newSelfMetrics(nil, nil)
+			var received int
+			srv := httptest.NewServer(relayHandler(tt.token, &allowed, self, func(p relay.Packet) error {
+				if reason := allowed.Load().Check(p); reason == "" {
+					received++
+				}
+				return nil
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if received != tt.received {
+				t.Errorf("received %d messages, want %d", received, tt.received)
+			}
+		})
+	}
+}
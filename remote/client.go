@@ -0,0 +1,137 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"tempest_exporter/tempest"
+)
+
+// Client sends samples to a remote metrics store.
+type Client interface {
+	Send(ctx context.Context, samples []tempest.Sample) error
+}
+
+type Options struct {
+	URL string
+
+	// Credentials for HTTP basic authentication, if Username is set
+	Username string
+	Password string
+
+	// A token to send in an Authorization header, if set
+	BearerToken string
+
+	// The number of times to retry a failed request, defaulting to 5
+	MaxRetries int
+
+	// The delay before the first retry, which doubles with each following retry up to MaxBackoff, defaulting to 1 second
+	// and 1 minute
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// The time limit for each request, defaulting to 30 seconds
+	Timeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.MinBackoff == 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = time.Minute
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 30 * time.Second
+	}
+	return o
+}
+
+// statusError is returned when the server rejects a request.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("server returned HTTP %d: %s", e.status, e.body)
+}
+
+// retryable reports whether a request which failed with err might succeed if sent again. The server telling us the
+// request is bad won't change, but network errors, server errors, and rate limiting might.
+func retryable(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.status >= 500 || se.status == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// Post sends body to the server, retrying with exponential backoff. Every sample carries its own timestamp, so sending a
+// request again after a failure which may have been partially applied writes the same data points, rather than
+// duplicating them.
+func Post(ctx context.Context, opts Options, body []byte, header http.Header) error {
+	opts = opts.withDefaults()
+	backoff := opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := postOnce(ctx, opts, body, header)
+		if err == nil {
+			return nil
+		}
+		if attempt >= opts.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		log.Printf("error sending to %s, retrying in %s: %v", opts.URL, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+func postOnce(ctx context.Context, opts Options, body []byte, header http.Header) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	if opts.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.BearerToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError{status: resp.StatusCode, body: string(bytes.TrimSpace(msg))}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,40 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+
+	"tempest_exporter/tempest"
+)
+
+// ImportClient sends samples in Prometheus text exposition format with timestamps, as accepted by VictoriaMetrics's
+// /api/v1/import/prometheus and similar import APIs.
+type ImportClient struct {
+	opts Options
+}
+
+func NewImportClient(opts Options) *ImportClient {
+	return &ImportClient{opts: opts.withDefaults()}
+}
+
+func (c *ImportClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	var line []byte
+	for _, s := range samples {
+		line = s.AppendText(line[:0])
+		if _, err := gzw.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+
+	return Post(ctx, c.opts, buf.Bytes(), http.Header{
+		"Content-Type":     {"text/plain; version=0.0.4"},
+		"Content-Encoding": {"gzip"},
+	})
+}
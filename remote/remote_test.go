@@ -0,0 +1,187 @@
+package remote
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testSamples(t *testing.T) []tempest.Sample {
+	t.Helper()
+	start := time.Unix(1688666400, 0)
+	var out []tempest.Sample
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		for _, m := range []prometheus.Metric{
+			prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, float64(20+i), "ST-00000001", "air"),
+			prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, float64(50+i), "ST-00000001"),
+		} {
+			s, err := tempest.NewSample(prometheus.NewMetricWithTimestamp(ts, m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// recorder is a stand-in for a remote store which fails the first few requests.
+type recorder struct {
+	mu       sync.Mutex
+	failures []int
+	requests int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests++
+	if len(r.failures) > 0 {
+		status := r.failures[0]
+		r.failures = r.failures[1:]
+		http.Error(w, "failure", status)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func testOptions(url string) Options {
+	return Options{URL: url, MaxRetries: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func TestImportClient(t *testing.T) {
+	rec := &recorder{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := NewImportClient(testOptions(srv.URL))
+	if err := c.Send(context.Background(), testSamples(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.requests != 3 || len(rec.bodies) != 1 {
+		t.Fatalf("got %d requests and %d accepted, want 3 and 1", rec.requests, len(rec.bodies))
+	}
+	gzr, err := gzip.NewReader(strings.NewReader(string(rec.bodies[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `tempest_temperature_c{instance="ST-00000001",kind="air"} 20 1688666400000
+tempest_humidity_percent{instance="ST-00000001"} 50 1688666400000
+tempest_temperature_c{instance="ST-00000001",kind="air"} 21 1688666460000
+tempest_humidity_percent{instance="ST-00000001"} 51 1688666460000
+tempest_temperature_c{instance="ST-00000001",kind="air"} 22 1688666520000
+tempest_humidity_percent{instance="ST-00000001"} 52 1688666520000
+`
+	if string(body) != want {
+		t.Errorf("body:\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestWriteClient(t *testing.T) {
+	rec := &recorder{failures: []int{http.StatusBadGateway}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := NewWriteClient(testOptions(srv.URL))
+	if err := c.Send(context.Background(), testSamples(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.bodies) != 1 {
+		t.Fatalf("got %d accepted requests, want 1", len(rec.bodies))
+	}
+	if got := rec.headers[0].Get("Content-Encoding"); got != "snappy" {
+		t.Errorf("Content-Encoding = %q, want snappy", got)
+	}
+
+	pb, err := snappy.Decode(nil, rec.bodies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(pb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{{Name: "__name__", Value: "tempest_temperature_c"}, {Name: "instance", Value: "ST-00000001"}, {Name: "kind", Value: "air"}},
+			Samples: []prompb.Sample{
+				{Value: 20, Timestamp: 1688666400000},
+				{Value: 21, Timestamp: 1688666460000},
+				{Value: 22, Timestamp: 1688666520000},
+			},
+		},
+		{
+			Labels: []prompb.Label{{Name: "__name__", Value: "tempest_humidity_percent"}, {Name: "instance", Value: "ST-00000001"}},
+			Samples: []prompb.Sample{
+				{Value: 50, Timestamp: 1688666400000},
+				{Value: 51, Timestamp: 1688666460000},
+				{Value: 52, Timestamp: 1688666520000},
+			},
+		},
+	}
+	if !reflect.DeepEqual(req.Timeseries, want) {
+		t.Errorf("timeseries = %+v, want %+v", req.Timeseries, want)
+	}
+}
+
+func TestClient_badRequest(t *testing.T) {
+	rec := &recorder{failures: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := NewWriteClient(testOptions(srv.URL))
+	if err := c.Send(context.Background(), testSamples(t)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if rec.requests != 1 {
+		t.Errorf("got %d requests, want 1 since bad requests should not be retried", rec.requests)
+	}
+}
+
+func TestClient_bearerToken(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	opts := testOptions(srv.URL)
+	opts.BearerToken = "token"
+	if err := NewWriteClient(opts).Send(context.Background(), testSamples(t)); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer token")
+	}
+}
@@ -0,0 +1,68 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"tempest_exporter/tempest"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// WriteClient sends samples using the Prometheus remote write protocol.
+type WriteClient struct {
+	opts Options
+}
+
+func NewWriteClient(opts Options) *WriteClient {
+	return &WriteClient{opts: opts.withDefaults()}
+}
+
+func (c *WriteClient) Send(ctx context.Context, samples []tempest.Sample) error {
+	pb, err := NewWriteRequest(samples).Marshal()
+	if err != nil {
+		return err
+	}
+
+	return Post(ctx, c.opts, snappy.Encode(nil, pb), http.Header{
+		"Content-Type":                      {"application/x-protobuf"},
+		"Content-Encoding":                  {"snappy"},
+		"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+	})
+}
+
+// NewWriteRequest groups samples by series, with each series' samples in timestamp order.
+func NewWriteRequest(samples []tempest.Sample) *prompb.WriteRequest {
+	index := make(map[string]int)
+	var req prompb.WriteRequest
+	var key []byte
+	for _, s := range samples {
+		key = s.AppendSeries(key[:0])
+		i, ok := index[string(key)]
+		if !ok {
+			i = len(req.Timeseries)
+			index[string(key)] = i
+
+			labels := make([]prompb.Label, 0, len(s.Labels)+1)
+			labels = append(labels, prompb.Label{Name: "__name__", Value: s.Family.Name})
+			for _, label := range s.Labels {
+				labels = append(labels, prompb.Label{Name: label.Name, Value: label.Value})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+			req.Timeseries = append(req.Timeseries, prompb.TimeSeries{Labels: labels})
+		}
+
+		req.Timeseries[i].Samples = append(req.Timeseries[i].Samples, prompb.Sample{
+			Value:     s.Value,
+			Timestamp: s.TimestampMs,
+		})
+	}
+
+	for _, ts := range req.Timeseries {
+		samples := ts.Samples
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+	}
+	return &req
+}
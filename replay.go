@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"tempest_exporter/relay"
+)
+
+func replay(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("replay", configPath)
+	speedFlag := fs.String("speed", "max", `"max" to replay as fast as the outputs allow, or a multiple of the original speed for captures, e.g. 1 or 60`)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	speed, err := parseSpeed(*speedFlag)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return usageError{err}
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+
+	var out outputs
+	if err := out.apply(cfg.Sinks); err != nil {
+		return err
+	}
+	sinks := &out.fanout
+
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		sinks.Run(runCtx)
+		close(done)
+	}()
+
+	var sent int
+	pace := pacer{speed: speed}
+	pipe := newPipeline(cfg)
+	err = eachPacket(fs.Args(), func(p relay.Packet) error {
+		if err := pace.wait(ctx, p.Time); err != nil {
+			return err
+		}
+		metrics, err := pipe.metrics(cfg, p.Message, p.Time)
+		if err != nil {
+			log.Printf("error parsing %s: %v", p.Message, err)
+			return nil
+		}
+		sent++
+
+		// Wait for room rather than dropping metrics, since we can go as fast as the sinks can
+		return sinks.SendWait(ctx, metrics)
+	})
+
+	// Wait for anything queued to be sent
+	stop()
+	<-done
+	log.Printf("replayed %d messages", sent)
+	return err
+}
+
+// parseSpeed parses a replay speed, returning zero for "max".
+func parseSpeed(s string) (float64, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(s, 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid speed %q: must be \"max\" or a positive number", s)
+	}
+	return speed, nil
+}
+
+// pacer spaces out captured packets as they were originally received, divided by speed. A speed of zero doesn't wait
+// at all, and neither do packets without a receive time.
+type pacer struct {
+	speed float64
+
+	// When the first packet was originally received, and when it was replayed
+	first   time.Time
+	started time.Time
+}
+
+func (p *pacer) wait(ctx context.Context, received time.Time) error {
+	if p.speed == 0 || received.IsZero() {
+		return nil
+	}
+	if p.first.IsZero() {
+		p.first, p.started = received, time.Now()
+		return nil
+	}
+
+	due := p.started.Add(time.Duration(float64(received.Sub(p.first)) / p.speed))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/dedup"
+	"tempest_exporter/qc"
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// selfMetrics keeps track of the exporter's own state, which is reported alongside the weather.
+type selfMetrics struct {
+	mu               sync.Mutex
+	reloadSuccessful bool
+	lastReload       time.Time
+
+	// Counts the duplicates dropped, if deduplicating
+	dedup *dedup.Filter
+
+	// Messages rejected by the source rules, by reason
+	rejections map[string]uint64
+
+	// Counts the readings which failed quality checks
+	qc *qc.Checker
+}
+
+func newSelfMetrics(seen *dedup.Filter, checker *qc.Checker) *selfMetrics {
+	return &selfMetrics{reloadSuccessful: true, lastReload: time.Now(), dedup: seen, rejections: make(map[string]uint64), qc: checker}
+}
+
+// reloaded records an attempt to reload the configuration.
+func (s *selfMetrics) reloaded(ok bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadSuccessful = ok
+	if ok {
+		s.lastReload = now
+	}
+}
+
+// rejected records a message rejected by the source rules.
+func (s *selfMetrics) rejected(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejections[reason]++
+}
+
+// Metrics returns the exporter's metrics, stamped with now.
+func (s *selfMetrics) Metrics(now time.Time) []prometheus.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	successful := 0.0
+	if s.reloadSuccessful {
+		successful = 1
+	}
+	metrics := []prometheus.Metric{
+		prometheus.NewMetricWithTimestamp(now, prometheus.MustNewConstMetric(tempest.ConfigReloadSuccessful, prometheus.GaugeValue, successful)),
+		prometheus.NewMetricWithTimestamp(now, prometheus.MustNewConstMetric(tempest.ConfigReloadTime, prometheus.GaugeValue, float64(s.lastReload.UnixMilli())/1000)),
+		prometheus.NewMetricWithTimestamp(now, prometheus.MustNewConstMetric(tempest.DuplicatesSuppressed, prometheus.CounterValue, float64(s.dedup.Suppressed()))),
+	}
+	for _, reason := range allowlist.Reasons {
+		metrics = append(metrics, prometheus.NewMetricWithTimestamp(now, prometheus.MustNewConstMetric(tempest.MessagesRejected, prometheus.CounterValue, float64(s.rejections[reason]), reason)))
+	}
+	return append(metrics, s.qc.Failures(now)...)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tempest_exporter/allowlist"
+	"tempest_exporter/capture"
+	"tempest_exporter/config"
+	"tempest_exporter/dedup"
+	"tempest_exporter/influx"
+	"tempest_exporter/mqtt"
+	"tempest_exporter/qc"
+	"tempest_exporter/relay"
+	"tempest_exporter/remote"
+	"tempest_exporter/sink"
+	"tempest_exporter/tempestapi"
+)
+
+func serve(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("serve", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+
+	var out outputs
+	if err := out.apply(cfg.Sinks); err != nil {
+		return err
+	}
+	var current atomic.Pointer[config.Config]
+	current.Store(cfg)
+	sources, err := allowlist.New(cfg.Sources.Options())
+	if err != nil {
+		return configError{err}
+	}
+	var allowed atomic.Pointer[allowlist.List]
+	allowed.Store(sources)
+
+	// The same message may arrive by more than one route
+	var seen *dedup.Filter
+	if c := cfg.Dedup; c.Window > 0 {
+		seen = dedup.New(c.Window, c.MaxEntries)
+	}
+	pipe := newPipeline(cfg)
+	self := newSelfMetrics(seen, pipe.checker)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		out.fanout.Run(ctx)
+		close(done)
+	}()
+
+	// Reload on SIGHUP, and report on ourselves periodically
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		out.fanout.Send(self.Metrics(time.Now()))
+		for {
+			select {
+			case <-hup:
+				self.reloaded(reload(*path, &current, &allowed, pipe.checker, &out), time.Now())
+				out.fanout.Send(self.Metrics(time.Now()))
+			case <-ticker.C:
+				out.fanout.Send(self.Metrics(time.Now()))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var recorder *capture.Writer
+	if c := cfg.Capture; c.Path != "" {
+		recorder, err = capture.NewWriter(capture.Options{Path: c.Path, MaxBytes: c.MaxBytes, MaxAge: c.MaxAge, Keep: c.Keep})
+		if err != nil {
+			return fmt.Errorf("error opening capture file: %w", err)
+		}
+		defer recorder.Close()
+		log.Printf("capturing messages to %s", c.Path)
+	}
+
+	receive := func(p relay.Packet) error {
+		if reason := allowed.Load().Check(p); reason != "" {
+			self.rejected(reason)
+			log.Printf("rejected message from %s by the %s rules: %s", p.Source, reason, p.Message)
+			return fmt.Errorf("rejected by the %s rules", reason)
+		}
+		log.Printf("UDP in: %s", string(p.Message))
+		if recorder != nil {
+			if err := recorder.Write(p); err != nil {
+				log.Printf("error capturing message: %v", err)
+			}
+		}
+		received := p.Time
+		if received.IsZero() {
+			received = time.Now()
+		}
+		if seen.Duplicate(p.Message, received) {
+			return nil
+		}
+		metrics, err := pipe.metrics(current.Load(), p.Message, received)
+		if err != nil {
+			log.Printf("error parsing report from %s: %s", p.Source, err)
+			return err
+		}
+		out.fanout.Send(metrics)
+		return nil
+	}
+
+	// Stop listening for UDP if the HTTP server fails
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+	httpErr := make(chan error, 1)
+	if c := cfg.Listen.HTTP; c.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/relay", relayHandler(c.Token, &allowed, self, receive))
+		mux.Handle("/ingest", ingestHandler(c.Token, func(b []byte, addr *net.UDPAddr) error {
+			return receive(relay.NewPacket(b, addr))
+		}))
+		go func() {
+			if err := serveHTTP(listenCtx, c.Addr, mux); err != nil {
+				httpErr <- err
+				stopListening()
+			}
+		}()
+	}
+
+	if c := cfg.Listen.WebSocket; c.Enabled {
+		client := tempestapi.NewClientWithEndpoints(cfg.Token, tempestapi.Endpoints{WebSocket: c.URL})
+		go client.Listen(listenCtx, func(b []byte) error {
+			receive(relay.Packet{Time: time.Now(), Source: "websocket", Message: b})
+			return nil
+		})
+	}
+
+	err = listen(listenCtx, cfg.Listen, func(b []byte, addr *net.UDPAddr) error {
+		receive(relay.NewPacket(b, addr))
+		return nil
+	})
+	select {
+	case err = <-httpErr:
+	default:
+	}
+
+	// Wait for anything queued to be sent
+	log.Printf("shutting down, sending queued metrics")
+	cancel()
+	<-done
+	return err
+}
+
+// reload loads the configuration again, applying changes to stations, sources, quality checks, and outputs. If anything
+// is wrong with the new configuration, the old one stays in effect.
+func reload(path string, current *atomic.Pointer[config.Config], allowed *atomic.Pointer[allowlist.List], checker *qc.Checker, out *outputs) bool {
+	old := current.Load()
+	cfg, err := config.Load(path)
+	var sources *allowlist.List
+	if err == nil {
+		sources, err = allowlist.New(cfg.Sources.Options())
+	}
+	if err == nil {
+		err = out.apply(cfg.Sinks)
+	}
+	if err != nil {
+		log.Printf("error reloading configuration, keeping the current one:\n%v", err)
+		return false
+	}
+	if !reflect.DeepEqual(cfg.Listen, old.Listen) {
+		log.Printf("listen addresses can't be changed without restarting, still listening as before")
+	}
+	if !reflect.DeepEqual(cfg.Capture, old.Capture) {
+		log.Printf("capture settings can't be changed without restarting, still capturing as before")
+	}
+	if !reflect.DeepEqual(cfg.Dedup, old.Dedup) {
+		log.Printf("dedup settings can't be changed without restarting, still deduplicating as before")
+	}
+	current.Store(cfg)
+	allowed.Store(sources)
+	checker.SetOptions(cfg.QC.Options())
+	log.Printf("reloaded configuration")
+	return true
+}
+
+// outputs manages the sinks built from the configuration. Reloading keeps the sinks whose settings are unchanged,
+// updating only their filters, and replaces the rest.
+type outputs struct {
+	fanout  sink.Fanout
+	current map[string]output
+}
+
+type output struct {
+	// The sink's configuration, less its filter
+	settings interface{}
+	queue    *sink.Queue
+}
+
+// apply configures a sink for each configured output, each limited to the metric families its filter allows.
+func (o *outputs) apply(cfg config.Sinks) error {
+	next := make(map[string]output)
+	var queues []*sink.Queue
+	var created []sink.Sink
+	add := func(name string, c interface{}, f config.Filter, opts sink.QueueOptions, create func() (sink.Sink, error)) error {
+		filter := sink.Filter{Include: f.Include, Exclude: f.Exclude}
+		settings := withoutFilter(c)
+		if prev, ok := o.current[name]; ok && reflect.DeepEqual(prev.settings, settings) {
+			prev.queue.SetFilter(filter)
+			next[name] = prev
+			queues = append(queues, prev.queue)
+			return nil
+		}
+
+		s, err := create()
+		if err != nil {
+			return err
+		}
+		created = append(created, s)
+		q := sink.NewQueue(name, s, filter, opts)
+		next[name] = output{settings: settings, queue: q}
+		queues = append(queues, q)
+		return nil
+	}
+
+	err := o.add(cfg, add)
+	if err == nil && len(queues) == 0 {
+		err = configError{errors.New("no outputs configured: set a URL, address, or path for at least one sink")}
+	}
+	if err != nil {
+		// Release anything we opened, since it won't be used
+		for _, s := range created {
+			if c, ok := s.(sink.Closer); ok {
+				c.Close()
+			}
+		}
+		return err
+	}
+
+	o.fanout.Replace(queues)
+	o.current = next
+	return nil
+}
+
+// withoutFilter returns a copy of a sink's configuration with its filter cleared, so that changing only the filter
+// doesn't replace the sink.
+func withoutFilter(c interface{}) interface{} {
+	v := reflect.New(reflect.TypeOf(c)).Elem()
+	v.Set(reflect.ValueOf(c))
+	v.FieldByName("Filter").Set(reflect.ValueOf(config.Filter{}))
+	return v.Interface()
+}
+
+type addFunc func(name string, c interface{}, f config.Filter, opts sink.QueueOptions, create func() (sink.Sink, error)) error
+
+func (o *outputs) add(cfg config.Sinks, add addFunc) error {
+	if c := cfg.Pushgateway; c.URL != "" {
+		if err := add("pushgateway", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			log.Printf("pushing to %q with job name %q", c.URL, c.Job)
+			return sink.NewPushgateway(c.URL, c.Job), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.RemoteWrite; c.URL != "" {
+		opts := sink.QueueOptions{BatchSize: c.BatchSize, FlushInterval: c.FlushInterval}
+		if err := add("remote write", c, c.Filter, opts, func() (sink.Sink, error) {
+			log.Printf("sending to %q using remote write", c.URL)
+			return sink.NewSamples(remote.NewWriteClient(remoteOptions(c))), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.Influx; c.URL != "" {
+		opts := sink.QueueOptions{FlushInterval: 5 * time.Second}
+		if err := add("influx", c, c.Filter, opts, func() (sink.Sink, error) {
+			log.Printf("sending to %q using InfluxDB line protocol", c.URL)
+			client, err := influx.NewHTTPClient(c.URL, c.Org, c.Bucket, c.Token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid InfluxDB URL: %w", err)
+			}
+			return sink.NewSamples(client), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.InfluxUDP; c.Addr != "" {
+		if err := add("influx udp", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			log.Printf("sending to %s using InfluxDB line protocol over UDP", c.Addr)
+			client, err := influx.NewUDPClient(c.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid InfluxDB UDP address: %w", err)
+			}
+			return sink.NewSamples(client), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.InfluxFile; c.Path != "" {
+		if err := add("influx file", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			log.Printf("writing to %s using InfluxDB line protocol", c.Path)
+			client, err := influx.NewFileClient(c.Path)
+			if err != nil {
+				return nil, fmt.Errorf("error opening InfluxDB line protocol file: %w", err)
+			}
+			return sink.NewSamples(client), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.MQTT; c.Broker != "" {
+		if err := add("mqtt", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			return mqtt.New(mqtt.Options{
+				Broker:          c.Broker,
+				ClientID:        c.ClientID,
+				Username:        c.Username,
+				Password:        c.Password,
+				TopicPrefix:     c.TopicPrefix,
+				DiscoveryPrefix: c.DiscoveryPrefix,
+				ExpireAfter:     c.ExpireAfter,
+			}), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.File; c.Path != "" {
+		if err := add("file", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			log.Printf("writing to %s", c.Path)
+			f, err := sink.NewFile(c.Path)
+			if err != nil {
+				return nil, fmt.Errorf("error opening file: %w", err)
+			}
+			return f, nil
+		}); err != nil {
+			return err
+		}
+	}
+	if c := cfg.Scrape; c.Addr != "" {
+		if err := add("scrape", c, c.Filter, sink.QueueOptions{}, func() (sink.Sink, error) {
+			return sink.NewScrape(c.Addr, c.ExpireAfter), nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveHTTP serves requests from elsewhere until ctx is done. Relayed streams don't end by themselves, so they are cut
+// off if they outlast a short grace period.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if srv.Shutdown(shutdownCtx) != nil {
+			srv.Close()
+		}
+	}()
+
+	log.Printf("accepting messages over HTTP on %s at /ingest and /relay", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func remoteOptions(c config.RemoteWrite) remote.Options {
+	return remote.Options{
+		URL:         c.URL,
+		Username:    c.Username,
+		Password:    c.Password,
+		BearerToken: c.BearerToken,
+	}
+}
+
+// relayHandler accepts streams from relays. With a token, a relay is trusted to report the address each message came
+// from, and the address rules are checked against that. Without one, anyone could claim any address, so the relay's
+// own address must pass the address rules too.
+func relayHandler(token string, allowed *atomic.Pointer[allowlist.List], self *selfMetrics, receive func(relay.Packet) error) http.Handler {
+	h := relay.Handler(token, func(p relay.Packet) {
+		receive(p)
+	})
+	if token != "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+		if err != nil || !allowed.Load().AllowsAddr(addr.IP) {
+			self.rejected(allowlist.Address)
+			log.Printf("rejected relay stream from %s by the address rules", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"tempest_exporter/simulator"
+)
+
+func simulate(ctx context.Context, configPath string, args []string) error {
+	fs, _ := commandFlags("simulate", configPath)
+	stations := fs.Int("stations", 1, "how many stations to simulate, each with its own hub")
+	scenario := fs.String("scenario", string(simulator.Fair), fmt.Sprintf("the weather: one of %v", simulator.Scenarios))
+	seed := fs.Int64("seed", 0, "seeds the weather so that runs can be repeated, or 0 for a random seed")
+	target := fs.String("target", "255.255.255.255:50222", `where to send messages over UDP, or "-" to print them`)
+	speedFlag := fs.String("speed", "1", `a multiple of real time, or "max" to generate the whole duration at once`)
+	duration := fs.Duration("duration", 0, "how much simulated time to run for, or 0 to run until interrupted")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	speed, err := parseSpeed(*speedFlag)
+	if err == nil && speed == 0 && *duration == 0 {
+		err = fmt.Errorf("-speed max needs a -duration")
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return usageError{err}
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	start := time.Now()
+	if speed == 0 {
+		// Finish now, rather than in the future
+		start = start.Add(-*duration)
+	}
+	sim, err := simulator.New(simulator.Options{
+		Stations: *stations,
+		Scenario: simulator.Scenario(strings.ToLower(*scenario)),
+		Seed:     *seed,
+	}, start)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return usageError{err}
+	}
+
+	var out io.Writer
+	if *target == "-" {
+		out = newlineWriter{os.Stdout}
+	} else {
+		conn, err := net.Dial("udp", *target)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		out = conn
+	}
+	log.Printf("simulating %d %s station(s) with seed %d, sending to %s", *stations, *scenario, *seed, *target)
+
+	end := start.Add(*duration)
+	send := func(until time.Time) error {
+		if *duration > 0 && until.After(end) {
+			until = end
+		}
+		for _, msg := range sim.Step(until) {
+			if _, err := out.Write(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if speed == 0 {
+		return send(end)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for *duration == 0 || sim.Now().Before(end) {
+		select {
+		case now := <-ticker.C:
+			if err := send(start.Add(time.Duration(float64(now.Sub(start)) * speed))); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// newlineWriter writes each message on its own line.
+type newlineWriter struct {
+	w io.Writer
+}
+
+func (n newlineWriter) Write(b []byte) (int, error) {
+	if _, err := n.w.Write(append(b[:len(b):len(b)], '\n')); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,176 @@
+package simulator
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Firmware revisions reported by the virtual devices
+const (
+	stationFirmware = 176
+	hubFirmware     = "177"
+)
+
+// Precipitation types in observations
+const (
+	precipNone = 0
+	precipRain = 1
+)
+
+func encode(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+type event struct {
+	SerialNumber string    `json:"serial_number"`
+	Type         string    `json:"type"`
+	HubSn        string    `json:"hub_sn"`
+	Evt          []float64 `json:"evt,omitempty"`
+	Ob           []float64 `json:"ob,omitempty"`
+}
+
+func (s *station) rainStart(sec int64) []byte {
+	return encode(event{SerialNumber: s.serial, Type: "evt_precip", HubSn: s.hub, Evt: []float64{float64(sec)}})
+}
+
+func (s *station) strike(sec int64, distanceKm float64, energy float64) []byte {
+	return encode(event{
+		SerialNumber: s.serial,
+		Type:         "evt_strike",
+		HubSn:        s.hub,
+		Evt:          []float64{float64(sec), math.Round(distanceKm), math.Round(energy)},
+	})
+}
+
+func (s *station) rapidWind(sec int64) []byte {
+	return encode(event{
+		SerialNumber: s.serial,
+		Type:         "rapid_wind",
+		HubSn:        s.hub,
+		Ob:           []float64{float64(sec), round(s.wind, 2), math.Round(s.windDirection)},
+	})
+}
+
+type observation struct {
+	SerialNumber     string      `json:"serial_number"`
+	Type             string      `json:"type"`
+	HubSn            string      `json:"hub_sn"`
+	Obs              [][]float64 `json:"obs"`
+	FirmwareRevision int         `json:"firmware_revision"`
+}
+
+// observation summarizes the last minute, in the order documented for obs_st.
+func (s *station) observation(now time.Time, storm float64) []byte {
+	lull, avg, gust := 0.0, 0.0, 0.0
+	if len(s.winds) > 0 {
+		lull = math.Inf(1)
+		for _, w := range s.winds {
+			lull, gust = math.Min(lull, w), math.Max(gust, w)
+			avg += w
+		}
+		avg /= float64(len(s.winds))
+	}
+
+	temp := s.temperature(now, storm)
+	irradiance := s.irradiance(now)
+	precipType := precipNone
+	if s.rain > 0 {
+		precipType = precipRain
+	}
+	strikeDist := 0.0
+	if s.strikes > 0 {
+		strikeDist = math.Round(s.strikeDistKm / float64(s.strikes))
+	}
+
+	return encode(observation{
+		SerialNumber: s.serial,
+		Type:         "obs_st",
+		HubSn:        s.hub,
+		Obs: [][]float64{{
+			float64(now.Unix()),
+			round(lull, 2),
+			round(avg, 2),
+			round(gust, 2),
+			math.Round(s.windDirection),
+			3,
+			round(s.pressure, 2),
+			round(temp, 2),
+			round(s.humidity(temp, storm), 2),
+			math.Round(irradiance * 120),
+			round(irradiance/100, 2),
+			math.Round(irradiance),
+			round(s.rain, 6),
+			float64(precipType),
+			strikeDist,
+			float64(s.strikes),
+			round(s.voltage, 3),
+			1,
+		}},
+		FirmwareRevision: stationFirmware,
+	})
+}
+
+type deviceStatus struct {
+	SerialNumber     string  `json:"serial_number"`
+	Type             string  `json:"type"`
+	HubSn            string  `json:"hub_sn"`
+	Timestamp        int64   `json:"timestamp"`
+	Uptime           int64   `json:"uptime"`
+	Voltage          float64 `json:"voltage"`
+	FirmwareRevision int     `json:"firmware_revision"`
+	Rssi             int     `json:"rssi"`
+	HubRssi          int     `json:"hub_rssi"`
+	SensorStatus     int     `json:"sensor_status"`
+	Debug            int     `json:"debug"`
+}
+
+func (s *station) deviceStatus(sec int64, elapsed time.Duration, rng *rand.Rand) []byte {
+	return encode(deviceStatus{
+		SerialNumber:     s.serial,
+		Type:             "device_status",
+		HubSn:            s.hub,
+		Timestamp:        sec,
+		Uptime:           int64(elapsed / time.Second),
+		Voltage:          round(s.voltage, 3),
+		FirmwareRevision: stationFirmware,
+		Rssi:             -75 + rng.Intn(10),
+		HubRssi:          -75 + rng.Intn(10),
+	})
+}
+
+type hubStatus struct {
+	SerialNumber     string    `json:"serial_number"`
+	Type             string    `json:"type"`
+	FirmwareRevision string    `json:"firmware_revision"`
+	Uptime           int64     `json:"uptime"`
+	Rssi             int       `json:"rssi"`
+	Timestamp        int64     `json:"timestamp"`
+	ResetFlags       string    `json:"reset_flags"`
+	Seq              int       `json:"seq"`
+	Fs               []int     `json:"fs"`
+	RadioStats       []float64 `json:"radio_stats"`
+	MqttStats        []int     `json:"mqtt_stats"`
+}
+
+func (s *station) hubStatus(sec int64, elapsed time.Duration) []byte {
+	s.seq++
+	return encode(hubStatus{
+		SerialNumber:     s.hub,
+		Type:             "hub_status",
+		FirmwareRevision: hubFirmware,
+		Uptime:           int64(elapsed / time.Second),
+		Rssi:             -44,
+		Timestamp:        sec,
+		ResetFlags:       "BOR,PIN,POR",
+		Seq:              s.seq,
+		Fs:               []int{1, 0, 15675411, 524288},
+		RadioStats:       []float64{25, 1, 0, 3, 16344},
+		MqttStats:        []int{1, 0},
+	})
+}
@@ -0,0 +1,88 @@
+// Package simulator generates the UDP messages of virtual Tempest stations, with plausible weather, so that outputs,
+// dashboards, and alerts can be tested without waiting for the real thing.
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Scenario selects the weather.
+type Scenario string
+
+const (
+	// Fair weather, following the usual daily cycle
+	Fair Scenario = "fair"
+
+	// Fair weather interrupted every two hours by an hour-long thunderstorm, starting ten minutes in
+	Storm Scenario = "storm"
+
+	// Fair weather with a battery which drains without charging, passing through each power saving mode
+	Drain Scenario = "drain"
+)
+
+// Scenarios lists the valid scenarios.
+var Scenarios = []Scenario{Fair, Storm, Drain}
+
+// Options configures a Simulator.
+type Options struct {
+	// How many stations to simulate, each with its own hub, defaulting to 1
+	Stations int
+
+	Scenario Scenario
+
+	// Seeds the random number generator, so that runs can be repeated
+	Seed int64
+}
+
+// Simulator generates messages for a set of virtual stations as simulated time advances.
+type Simulator struct {
+	opts     Options
+	rng      *rand.Rand
+	stations []*station
+	start    time.Time
+	now      time.Time
+}
+
+// New returns a simulator whose time starts at start.
+func New(opts Options, start time.Time) (*Simulator, error) {
+	if opts.Stations <= 0 {
+		opts.Stations = 1
+	}
+	switch opts.Scenario {
+	case "":
+		opts.Scenario = Fair
+	case Fair, Storm, Drain:
+	default:
+		return nil, fmt.Errorf("unknown scenario %q", opts.Scenario)
+	}
+
+	s := &Simulator{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(opts.Seed)),
+		start: start.Truncate(time.Second),
+	}
+	s.now = s.start
+	for i := 0; i < opts.Stations; i++ {
+		s.stations = append(s.stations, newStation(s.rng, i+1, opts.Scenario, s.start))
+	}
+	return s, nil
+}
+
+// Step advances simulated time to now, returning every message due in the meantime, in order.
+func (s *Simulator) Step(now time.Time) [][]byte {
+	var messages [][]byte
+	for now.Sub(s.now) >= time.Second {
+		s.now = s.now.Add(time.Second)
+		for _, st := range s.stations {
+			messages = append(messages, st.step(s.rng, s.now, s.now.Sub(s.start))...)
+		}
+	}
+	return messages
+}
+
+// Now returns the current simulated time.
+func (s *Simulator) Now() time.Time {
+	return s.now
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempestudp"
+)
+
+func TestSimulator(t *testing.T) {
+	start := time.Date(2023, 7, 6, 12, 0, 0, 0, time.UTC)
+	s, err := New(Options{Stations: 2, Scenario: Storm, Seed: 1}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := s.Step(start.Add(2 * time.Hour))
+
+	types := make(map[string]int)
+	var maxHumidity, minPressure float64 = 0, 2000
+	for _, msg := range messages {
+		if _, err := tempestudp.ParseReport(msg); err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+		var m struct {
+			Type string      `json:"type"`
+			Obs  [][]float64 `json:"obs"`
+		}
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatal(err)
+		}
+		types[m.Type]++
+
+		for _, ob := range m.Obs {
+			lull, avg, gust, pressure, temp, humidity, voltage := ob[1], ob[2], ob[3], ob[6], ob[7], ob[8], ob[16]
+			if !(0 <= lull && lull <= avg && avg <= gust && gust < 60) {
+				t.Errorf("implausible wind %v/%v/%v", lull, avg, gust)
+			}
+			if temp < -10 || temp > 45 || humidity < 5 || humidity > 100 || pressure < 950 || pressure > 1060 {
+				t.Errorf("implausible observation %v", ob)
+			}
+			if voltage < 2.3 || voltage > 2.9 {
+				t.Errorf("implausible voltage %v", voltage)
+			}
+			if humidity > maxHumidity {
+				maxHumidity = humidity
+			}
+			if pressure < minPressure {
+				minPressure = pressure
+			}
+		}
+	}
+
+	// Two stations over two hours
+	for typ, want := range map[string]int{
+		"rapid_wind":    2 * 2400,
+		"obs_st":        2 * 120,
+		"device_status": 2 * 120,
+		"hub_status":    2 * 720,
+	} {
+		if types[typ] != want {
+			t.Errorf("%d %s messages, want %d", types[typ], typ, want)
+		}
+	}
+
+	// The storm brings rain, lightning, near saturation, and a pressure trough
+	if types["evt_precip"] == 0 || types["evt_strike"] == 0 {
+		t.Errorf("storm produced %d rain and %d lightning events", types["evt_precip"], types["evt_strike"])
+	}
+	if maxHumidity < 90 {
+		t.Errorf("humidity peaked at %v%%", maxHumidity)
+	}
+	if minPressure > 1008 {
+		t.Errorf("pressure bottomed out at %v hPa", minPressure)
+	}
+
+	// The same seed gives the same weather
+	again, _ := New(Options{Stations: 2, Scenario: Storm, Seed: 1}, start)
+	if !reflect.DeepEqual(again.Step(start.Add(2*time.Hour)), messages) {
+		t.Error("not repeatable")
+	}
+}
+
+func TestSimulator_drain(t *testing.T) {
+	start := time.Date(2023, 7, 6, 12, 0, 0, 0, time.UTC)
+	s, err := New(Options{Scenario: Drain}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, last float64
+	for _, msg := range s.Step(start.Add(6 * time.Hour)) {
+		var m struct {
+			Type    string  `json:"type"`
+			Voltage float64 `json:"voltage"`
+		}
+		json.Unmarshal(msg, &m)
+		if m.Type != "device_status" {
+			continue
+		}
+		if first == 0 {
+			first = m.Voltage
+		}
+		last = m.Voltage
+	}
+	if last > 2.4 || last >= first {
+		t.Errorf("voltage went from %v to %v in the sunshine", first, last)
+	}
+
+	if _, err := New(Options{Scenario: "hurricane"}, start); err == nil {
+		t.Error("expected an error for an unknown scenario")
+	}
+}
@@ -0,0 +1,183 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// How often each message is sent, as by a real hub
+const (
+	rapidWindInterval   = 3 * time.Second
+	observationInterval = time.Minute
+	hubStatusInterval   = 10 * time.Second
+)
+
+// station is the weather at one virtual station, and the state of its devices.
+type station struct {
+	serial   string
+	hub      string
+	scenario Scenario
+
+	// Per-station variations, so that stations differ
+	meanTemp float64
+	latitude float64
+
+	// Slowly wandering values
+	dewPointOffset float64
+	pressure       float64
+	windDirection  float64
+	cloud          float64
+	voltage        float64
+
+	// Three second wind samples over the current minute, for the observation's lull, average, and gust
+	wind  float64
+	winds []float64
+
+	// Rain and lightning over the current minute
+	raining      bool
+	rain         float64
+	strikes      int
+	strikeDistKm float64
+
+	seq int
+}
+
+func newStation(rng *rand.Rand, n int, scenario Scenario, start time.Time) *station {
+	return &station{
+		serial:        fmt.Sprintf("ST-9%07d", n),
+		hub:           fmt.Sprintf("HB-9%07d", n),
+		scenario:      scenario,
+		meanTemp:      12 + rng.Float64()*8,
+		latitude:      35 + rng.Float64()*15,
+		pressure:      1013 + rng.NormFloat64()*4,
+		windDirection: rng.Float64() * 360,
+		cloud:         rng.Float64() * 0.3,
+		voltage:       2.65 + rng.Float64()*0.1,
+		wind:          2,
+	}
+}
+
+// storm returns the intensity of the scripted storm at elapsed time into the simulation, from 0 to 1, rising and
+// falling over an hour starting ten minutes into each two hour cycle.
+func (s *station) storm(elapsed time.Duration) float64 {
+	if s.scenario != Storm {
+		return 0
+	}
+	into := (elapsed % (2 * time.Hour)) - 10*time.Minute
+	if into < 0 || into > time.Hour {
+		return 0
+	}
+	return math.Sin(math.Pi * into.Hours())
+}
+
+// step advances the station by one second, returning any messages due.
+func (s *station) step(rng *rand.Rand, now time.Time, elapsed time.Duration) [][]byte {
+	storm := s.storm(elapsed)
+	sec := now.Unix()
+
+	// Wind gusts around a mean which rises with the storm
+	mean := 2 + 1.5*s.sunElevation(now) + 14*storm
+	s.wind += 0.2*(mean-s.wind) + rng.NormFloat64()*0.4*(1+storm)
+	if rng.Float64() < 0.01 {
+		s.wind += rng.Float64() * mean
+	}
+	s.wind = math.Max(0, s.wind)
+	s.windDirection = math.Mod(s.windDirection+rng.NormFloat64()*(3+10*storm)+360, 360)
+
+	// The storm brings clouds, rain, and lightning
+	s.cloud = clamp(s.cloud+rng.NormFloat64()*0.002+0.01*(storm-s.cloud), 0, 1)
+	var messages [][]byte
+	if storm > 0.3 {
+		s.rain += storm * storm * 0.05 * rng.Float64()
+		if !s.raining {
+			s.raining = true
+			messages = append(messages, s.rainStart(sec))
+		}
+	} else {
+		s.raining = false
+	}
+	if storm > 0 && rng.Float64() < storm*storm*0.1 {
+		// Strikes approach as the storm builds, and recede as it passes
+		distance := math.Max(1, 40*(1-storm)+rng.NormFloat64()*3)
+		s.strikes++
+		s.strikeDistKm += distance
+		messages = append(messages, s.strike(sec, distance, rng.Float64()*20000))
+	}
+
+	// The battery charges in sunshine, and otherwise drains slowly
+	charge := 0.0
+	if s.scenario != Drain {
+		charge = s.irradiance(now) * 2e-7
+	}
+	drain := 2e-6
+	if s.scenario == Drain {
+		drain = 2e-5
+	}
+	s.voltage = clamp(s.voltage+charge-drain, 2.3, 2.85)
+
+	// The front brings a pressure trough
+	target := 1013 - 8*storm
+	s.pressure += 0.001*(target-s.pressure) + rng.NormFloat64()*0.002
+	s.dewPointOffset = clamp(s.dewPointOffset+rng.NormFloat64()*0.01, -3, 3)
+
+	if sec%int64(rapidWindInterval/time.Second) == 0 {
+		s.winds = append(s.winds, s.wind)
+		messages = append(messages, s.rapidWind(sec))
+	}
+	if sec%int64(hubStatusInterval/time.Second) == 0 {
+		messages = append(messages, s.hubStatus(sec, elapsed))
+	}
+	if sec%int64(observationInterval/time.Second) == 0 {
+		messages = append(messages, s.observation(now, storm), s.deviceStatus(sec, elapsed, rng))
+		s.winds, s.rain, s.strikes, s.strikeDistKm = nil, 0, 0, 0
+	}
+	return messages
+}
+
+// sunElevation returns the sine of the sun's elevation, or zero at night, using the local time of day as solar time.
+func (s *station) sunElevation(now time.Time) float64 {
+	day := float64(now.YearDay())
+	hour := float64(now.Hour()) + float64(now.Minute())/60 + float64(now.Second())/3600
+	declination := -23.44 * math.Cos(2*math.Pi*(day+10)/365) * math.Pi / 180
+	hourAngle := (hour - 12) * 15 * math.Pi / 180
+	lat := s.latitude * math.Pi / 180
+	return math.Max(0, math.Sin(lat)*math.Sin(declination)+math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle))
+}
+
+// irradiance returns the solar irradiance in W/m².
+func (s *station) irradiance(now time.Time) float64 {
+	return 1000 * s.sunElevation(now) * (1 - 0.75*s.cloud)
+}
+
+// temperature follows the daily cycle, peaking mid-afternoon, and is cooled by the storm.
+func (s *station) temperature(now time.Time, storm float64) float64 {
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	return s.meanTemp + 6*math.Sin(2*math.Pi*(hour-9)/24) - 6*storm
+}
+
+// humidity follows from the temperature and a dew point which changes more slowly, so that humidity falls as the day
+// warms, and rises to near saturation in the storm.
+func (s *station) humidity(temp float64, storm float64) float64 {
+	dewPoint := s.meanTemp - 7 + s.dewPointOffset
+	dewPoint += storm * (temp - dewPoint) * 0.95
+	if dewPoint > temp {
+		dewPoint = temp
+	}
+	return clamp(100*magnus(dewPoint)/magnus(temp), 5, 100)
+}
+
+// magnus returns the saturation vapour pressure at a temperature, in hPa.
+func magnus(temp float64) float64 {
+	return 6.112 * math.Exp(17.62*temp/(243.12+temp))
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
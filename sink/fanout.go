@@ -0,0 +1,167 @@
+package sink
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fanout sends every metric to each of several sinks, each with its own queue.
+type Fanout struct {
+	// mu guards queues, so that metrics are never added to a queue once it has been replaced
+	mu     sync.RWMutex
+	queues []*Queue
+
+	// lifecycle guards the running queues, which are started and stopped by Run and Replace
+	lifecycle sync.Mutex
+	running   map[*Queue]func()
+}
+
+func (f *Fanout) Add(q *Queue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queues = append(f.queues, q)
+}
+
+func (f *Fanout) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.queues)
+}
+
+// Queues returns the current queues.
+func (f *Fanout) Queues() []*Queue {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]*Queue(nil), f.queues...)
+}
+
+// Send queues metrics for every sink.
+func (f *Fanout) Send(metrics []prometheus.Metric) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, q := range f.queues {
+		q.Enqueue(metrics)
+	}
+}
+
+// SendWait queues metrics for every sink, waiting for room in each queue rather than dropping metrics. This suits
+// sources which can be slowed down, such as a replay.
+func (f *Fanout) SendWait(ctx context.Context, metrics []prometheus.Metric) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, q := range f.queues {
+		if err := q.Put(ctx, metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs every queue, along with any sink which needs to run in the background, until ctx is done. It returns once
+// every queue has drained and every sink has shut down.
+func (f *Fanout) Run(ctx context.Context) {
+	f.lifecycle.Lock()
+	f.running = make(map[*Queue]func())
+	for _, q := range f.Queues() {
+		f.running[q] = start(q)
+	}
+	f.lifecycle.Unlock()
+
+	<-ctx.Done()
+
+	f.lifecycle.Lock()
+	defer f.lifecycle.Unlock()
+	stopAll(f.running)
+	f.running = nil
+}
+
+// Replace swaps the current queues for a new set. Queues in both sets carry on undisturbed. Queues which are no longer
+// present are drained and their sinks shut down before any new queues start, so that a new sink can take over
+// whatever the old one held, such as a listening port; metrics sent in the meantime wait in the new queues.
+func (f *Fanout) Replace(queues []*Queue) {
+	f.lifecycle.Lock()
+	defer f.lifecycle.Unlock()
+
+	f.mu.Lock()
+	old := f.queues
+	f.queues = append([]*Queue(nil), queues...)
+	f.mu.Unlock()
+
+	if f.running == nil {
+		// Not running, so there's nothing to start or stop
+		return
+	}
+
+	keep := make(map[*Queue]bool, len(queues))
+	for _, q := range queues {
+		keep[q] = true
+	}
+	removed := make(map[*Queue]func())
+	for _, q := range old {
+		if !keep[q] {
+			removed[q] = f.running[q]
+			delete(f.running, q)
+		}
+	}
+	stopAll(removed)
+
+	for _, q := range queues {
+		if _, ok := f.running[q]; !ok {
+			f.running[q] = start(q)
+		}
+	}
+}
+
+// start runs a queue and its sink in the background, returning a function which stops them once the queue has
+// drained.
+func start(q *Queue) (stop func()) {
+	// The sink must outlive its queue, so it can accept whatever is drained at shutdown
+	sinkCtx, stopSink := context.WithCancel(context.Background())
+	sinkDone := make(chan struct{})
+	if r, ok := q.sink.(Runner); ok {
+		go func() {
+			defer close(sinkDone)
+			if err := r.Run(sinkCtx); err != nil {
+				log.Printf("%s: %v", q.name, err)
+			}
+		}()
+	} else {
+		close(sinkDone)
+	}
+
+	queueCtx, stopQueue := context.WithCancel(context.Background())
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		q.Run(queueCtx)
+	}()
+
+	return func() {
+		stopQueue()
+		<-queueDone
+		stopSink()
+		<-sinkDone
+
+		if c, ok := q.sink.(Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("%s: error closing: %v", q.name, err)
+			}
+		}
+	}
+}
+
+// stopAll stops queues concurrently, so that one slow sink doesn't hold up the others.
+func stopAll(running map[*Queue]func()) {
+	var wg sync.WaitGroup
+	for _, stop := range running {
+		wg.Add(1)
+		go func(stop func()) {
+			defer wg.Done()
+			stop()
+		}(stop)
+	}
+	wg.Wait()
+}
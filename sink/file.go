@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// File appends metrics to a file in Prometheus text format, with timestamps.
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFile(name string) (*File, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+func (f *File) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	var b []byte
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			return err
+		}
+		b = s.AppendText(b)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.f.Write(b)
+	return err
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
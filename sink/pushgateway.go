@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"context"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Pushgateway pushes metrics to a Prometheus Pushgateway or a compatible service.
+type Pushgateway struct {
+	url string
+	job string
+}
+
+func NewPushgateway(url string, job string) *Pushgateway {
+	if job == "" {
+		job = "tempest"
+	}
+	return &Pushgateway{url: url, job: job}
+}
+
+func (p *Pushgateway) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	c := latestCollector(metrics)
+	return push.New(p.url, p.job).Collector(c).Format(expfmt.FmtText).AddContext(ctx)
+}
+
+// latestCollector collects the most recent of each series, since a registry refuses to gather a series twice.
+type latestCollector []prometheus.Metric
+
+// Describe describes nothing, making this an unchecked collector, since flagged readings carry a label which isn't part
+// of their family's Desc.
+func (c latestCollector) Describe(descs chan<- *prometheus.Desc) {
+}
+
+func (c latestCollector) Collect(metrics chan<- prometheus.Metric) {
+	latest := make(map[string]int)
+	var order []string
+	var key []byte
+	for i, m := range c {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			continue
+		}
+		key = s.AppendSeries(key[:0])
+		if _, ok := latest[string(key)]; !ok {
+			order = append(order, string(key))
+		}
+		latest[string(key)] = i
+	}
+	for _, k := range order {
+		metrics <- c[latest[k]]
+	}
+}
@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type QueueOptions struct {
+	// The number of metrics which can wait to be sent before further metrics are dropped, defaulting to 10 batches
+	Capacity int
+
+	// The largest number of metrics passed to a single Send, defaulting to 1000
+	BatchSize int
+
+	// How long to wait for a batch to fill before sending it anyway, or 0 to send whatever is waiting as soon as the
+	// sink is ready
+	FlushInterval time.Duration
+
+	// How long to keep trying to send queued metrics when shutting down, defaulting to 10 seconds
+	DrainTimeout time.Duration
+}
+
+// Queue holds metrics for a single sink, sending them in batches from its own goroutine so that a slow or failing sink
+// never holds up the others.
+type Queue struct {
+	name    string
+	sink    Sink
+	filter  atomic.Pointer[Filter]
+	opts    QueueOptions
+	inbox   chan prometheus.Metric
+	dropped atomic.Int64
+}
+
+func NewQueue(name string, sink Sink, filter Filter, opts QueueOptions) *Queue {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = 10 * opts.BatchSize
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 10 * time.Second
+	}
+	q := &Queue{
+		name:  name,
+		sink:  sink,
+		opts:  opts,
+		inbox: make(chan prometheus.Metric, opts.Capacity),
+	}
+	q.SetFilter(filter)
+	return q
+}
+
+// SetFilter changes which metrics the queue accepts from now on.
+func (q *Queue) SetFilter(filter Filter) {
+	q.filter.Store(&filter)
+}
+
+func (q *Queue) allows(m prometheus.Metric) bool {
+	filter := q.filter.Load()
+	if filter.empty() {
+		return true
+	}
+	family := tempest.Lookup(m.Desc())
+	return family == nil || filter.Allows(family.Name)
+}
+
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Enqueue adds the metrics which pass the queue's filter, without blocking. If the queue is full, the metrics are
+// dropped.
+func (q *Queue) Enqueue(metrics []prometheus.Metric) {
+	for i, m := range metrics {
+		if !q.allows(m) {
+			continue
+		}
+
+		select {
+		case q.inbox <- m:
+		default:
+			n := q.dropped.Add(int64(len(metrics) - i))
+			log.Printf("%s: queue full, dropped %d metrics so far", q.name, n)
+			return
+		}
+	}
+}
+
+// Put adds the metrics which pass the queue's filter, waiting for room if the queue is full. It returns early only if
+// ctx is done.
+func (q *Queue) Put(ctx context.Context, metrics []prometheus.Metric) error {
+	for _, m := range metrics {
+		if !q.allows(m) {
+			continue
+		}
+
+		select {
+		case q.inbox <- m:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of metrics dropped because the queue was full.
+func (q *Queue) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Run sends batches until ctx is done, then sends whatever remains before returning.
+func (q *Queue) Run(ctx context.Context) {
+	batch := make([]prometheus.Metric, 0, q.opts.BatchSize)
+	var timer *time.Timer
+	var timeout <-chan time.Time
+
+	send := func(ctx context.Context) {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.sink.Send(ctx, batch); err != nil {
+			if ctx.Err() != nil {
+				// We're stopping, so hold on to the batch for the final attempt
+				return
+			}
+			log.Printf("%s: error sending %d metrics: %v", q.name, len(batch), err)
+		}
+		batch = make([]prometheus.Metric, 0, q.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case m := <-q.inbox:
+			batch = append(batch, m)
+			if q.opts.FlushInterval <= 0 {
+				// Take whatever else is waiting, then send
+				q.fill(&batch)
+			} else if timer == nil {
+				timer = time.NewTimer(q.opts.FlushInterval)
+				timeout = timer.C
+			}
+			if (q.opts.FlushInterval <= 0 || len(batch) >= q.opts.BatchSize) && ctx.Err() == nil {
+				send(ctx)
+			}
+
+		case <-timeout:
+			send(ctx)
+
+		case <-ctx.Done():
+			// Give the remaining metrics a bounded amount of time to go out
+			drainCtx, cancel := context.WithTimeout(context.Background(), q.opts.DrainTimeout)
+			defer cancel()
+			for {
+				q.fill(&batch)
+				if len(batch) == 0 {
+					return
+				}
+				send(drainCtx)
+				if drainCtx.Err() != nil {
+					log.Printf("%s: gave up sending %d metrics while shutting down", q.name, len(batch)+len(q.inbox))
+					return
+				}
+			}
+		}
+	}
+}
+
+// fill moves waiting metrics into batch, up to the batch size, without blocking.
+func (q *Queue) fill(batch *[]prometheus.Metric) {
+	for len(*batch) < q.opts.BatchSize {
+		select {
+		case m := <-q.inbox:
+			*batch = append(*batch, m)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+	"log"
+
+	"tempest_exporter/remote"
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Samples adapts a client which accepts flattened samples, such as remote write or InfluxDB, into a Sink.
+type Samples struct {
+	client remote.Client
+}
+
+func NewSamples(client remote.Client) *Samples {
+	return &Samples{client: client}
+}
+
+func (s *Samples) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	samples := make([]tempest.Sample, 0, len(metrics))
+	for _, m := range metrics {
+		sample, err := tempest.NewSample(m)
+		if err != nil {
+			log.Printf("error converting metric: %v", err)
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return s.client.Send(ctx, samples)
+}
+
+func (s *Samples) Close() error {
+	if c, ok := s.client.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Scrape serves the latest value of each series on an HTTP endpoint for Prometheus to scrape.
+//
+// Values are served without timestamps, so Prometheus records them at scrape time and marks them stale as usual once
+// they disappear. A series disappears once it has gone without an update for longer than the expiry time.
+type Scrape struct {
+	addr        string
+	expireAfter time.Duration
+
+	mu     sync.Mutex
+	latest map[string]scraped
+}
+
+type scraped struct {
+	sample    tempest.Sample
+	updatedAt time.Time
+}
+
+func NewScrape(addr string, expireAfter time.Duration) *Scrape {
+	if expireAfter <= 0 {
+		expireAfter = 5 * time.Minute
+	}
+	return &Scrape{
+		addr:        addr,
+		expireAfter: expireAfter,
+		latest:      make(map[string]scraped),
+	}
+}
+
+func (s *Scrape) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var key []byte
+	for _, m := range metrics {
+		sample, err := tempest.NewSample(m)
+		if err != nil {
+			return err
+		}
+		key = sample.AppendSeries(key[:0])
+		if prev, ok := s.latest[string(key)]; ok && prev.sample.TimestampMs > sample.TimestampMs {
+			continue
+		}
+		s.latest[string(key)] = scraped{sample: sample, updatedAt: now}
+	}
+	return nil
+}
+
+// Describe describes nothing, making Scrape an unchecked collector, since flagged readings carry a label which isn't
+// part of their family's Desc.
+func (s *Scrape) Describe(descs chan<- *prometheus.Desc) {
+}
+
+func (s *Scrape) Collect(metrics chan<- prometheus.Metric) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, l := range s.latest {
+		if now.Sub(l.updatedAt) > s.expireAfter {
+			delete(s.latest, key)
+			continue
+		}
+
+		family := l.sample.Family
+		values := make([]string, len(family.Labels))
+		for i, name := range family.Labels {
+			values[i] = l.sample.Label(name)
+		}
+		m, err := prometheus.NewConstMetric(family.Desc, family.Type, l.sample.Value, values...)
+		if err != nil {
+			log.Printf("error collecting %s: %v", key, err)
+			continue
+		}
+		if check := l.sample.Label(tempest.QualityLabel); check != "" {
+			m = tempest.Flag(m, check)
+		}
+		metrics <- m
+	}
+}
+
+// Handler returns an http.Handler which serves the metrics.
+func (s *Scrape) Handler() http.Handler {
+	r := prometheus.NewRegistry()
+	r.MustRegister(s)
+	return promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+}
+
+// Run serves /metrics until ctx is done.
+func (s *Scrape) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.Handler())
+	srv := &http.Server{Addr: s.addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving metrics on %s/metrics", s.addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
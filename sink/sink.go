@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sink is a destination for the metrics produced from each report.
+type Sink interface {
+	// Send delivers metrics, returning once they have been delivered or have failed. Sends to a single sink are never
+	// concurrent.
+	Send(ctx context.Context, metrics []prometheus.Metric) error
+}
+
+// Runner is implemented by sinks which need to do work in the background, such as maintaining a connection. Run
+// returns once ctx is done and the sink has shut down.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// Closer is implemented by sinks which hold resources which must be released once they are no longer used.
+type Closer interface {
+	Close() error
+}
+
+// Filter selects metric families by name, using shell-style patterns as in path.Match.
+type Filter struct {
+	// If not empty, only families matching one of these patterns are included
+	Include []string
+
+	// Families matching any of these patterns are excluded, even if they are included above
+	Exclude []string
+}
+
+// ParseFilter parses comma-separated include and exclude pattern lists.
+func ParseFilter(include string, exclude string) (Filter, error) {
+	f := Filter{Include: splitList(include), Exclude: splitList(exclude)}
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Filter{}, err
+		}
+	}
+	return f, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Allows reports whether the named family passes the filter.
+func (f Filter) Allows(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func (f Filter) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,357 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testMetric(t *testing.T, desc *prometheus.Desc, value float64, timestamp time.Time, labels ...string) prometheus.Metric {
+	t.Helper()
+	family := tempest.Lookup(desc)
+	m, err := prometheus.NewConstMetric(desc, family.Type, value, labels...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prometheus.NewMetricWithTimestamp(timestamp, m)
+}
+
+// recorder is a Sink which records each batch it is sent, optionally blocking or failing.
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]prometheus.Metric
+	block   chan struct{}
+	err     error
+}
+
+func (r *recorder) Send(ctx context.Context, metrics []prometheus.Metric) error {
+	if r.block != nil {
+		select {
+		case <-r.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, metrics)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, b := range r.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		family  string
+		want    bool
+	}{
+		{"empty", "", "", "tempest_rssi_dbm", true},
+		{"included", "tempest_temperature_c, tempest_humidity_*", "", "tempest_humidity_percent", true},
+		{"not included", "tempest_temperature_c", "", "tempest_rssi_dbm", false},
+		{"excluded", "", "tempest_rssi_*,tempest_uptime_*", "tempest_uptime_seconds_total", false},
+		{"not excluded", "", "tempest_rssi_*", "tempest_uptime_seconds_total", true},
+		{"included and excluded", "tempest_*", "tempest_rssi_dbm", "tempest_rssi_dbm", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Allows(tt.family); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.family, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseFilter("tempest_[", ""); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	now := time.Now()
+	var metrics []prometheus.Metric
+	for i := 0; i < 5; i++ {
+		metrics = append(metrics,
+			testMetric(t, tempest.Rssi, float64(-i), now, "ST-00019709"),
+			testMetric(t, tempest.Humidity, float64(i), now, "ST-00019709"))
+	}
+
+	r := &recorder{}
+	filter, _ := ParseFilter("", "tempest_rssi_dbm")
+	q := NewQueue("test", r, filter, QueueOptions{BatchSize: 2, FlushInterval: time.Hour})
+	q.Enqueue(metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	// Full batches go out straight away, leaving the odd one waiting for the flush interval
+	deadline := time.Now().Add(5 * time.Second)
+	for r.count() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stopping sends whatever is left
+	cancel()
+	<-done
+
+	if r.count() != 5 {
+		t.Fatalf("sent %d metrics, want 5", r.count())
+	}
+	for _, b := range r.batches {
+		if len(b) > 2 {
+			t.Errorf("batch of %d exceeds the batch size", len(b))
+		}
+		for _, m := range b {
+			if m.Desc() == tempest.Rssi {
+				t.Error("excluded metric was sent")
+			}
+		}
+	}
+}
+
+func TestQueue_full(t *testing.T) {
+	now := time.Now()
+	r := &recorder{}
+	q := NewQueue("test", r, Filter{}, QueueOptions{Capacity: 3})
+	q.Enqueue([]prometheus.Metric{
+		testMetric(t, tempest.Rssi, -1, now, "ST-00019709"),
+		testMetric(t, tempest.Rssi, -2, now, "ST-00019709"),
+	})
+	q.Enqueue([]prometheus.Metric{
+		testMetric(t, tempest.Rssi, -3, now, "ST-00019709"),
+		testMetric(t, tempest.Rssi, -4, now, "ST-00019709"),
+		testMetric(t, tempest.Rssi, -5, now, "ST-00019709"),
+	})
+	if got := q.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+}
+
+func TestQueue_drainTimeout(t *testing.T) {
+	r := &recorder{block: make(chan struct{})}
+	q := NewQueue("test", r, Filter{}, QueueOptions{DrainTimeout: 50 * time.Millisecond})
+	q.Enqueue([]prometheus.Metric{testMetric(t, tempest.Rssi, -1, time.Now(), "ST-00019709")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not give up on a stuck sink")
+	}
+}
+
+func TestFanout(t *testing.T) {
+	stuck := &recorder{block: make(chan struct{})}
+	failing := &recorder{err: errors.New("unavailable")}
+	ok := &recorder{}
+
+	var f Fanout
+	f.Add(NewQueue("stuck", stuck, Filter{}, QueueOptions{DrainTimeout: 50 * time.Millisecond}))
+	f.Add(NewQueue("failing", failing, Filter{}, QueueOptions{}))
+	f.Add(NewQueue("ok", ok, Filter{}, QueueOptions{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		f.Send([]prometheus.Metric{testMetric(t, tempest.Rssi, float64(-i), time.Now(), "ST-00019709")})
+	}
+
+	// Neither the stuck nor the failing sink holds up the other
+	deadline := time.Now().Add(5 * time.Second)
+	for ok.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if ok.count() != 3 {
+		t.Errorf("sent %d metrics, want 3", ok.count())
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestFanout_Replace(t *testing.T) {
+	kept, removed, added := &recorder{}, &recorder{}, &recorder{}
+	keptQ := NewQueue("kept", kept, Filter{}, QueueOptions{})
+	removedQ := NewQueue("removed", removed, Filter{}, QueueOptions{FlushInterval: time.Hour, BatchSize: 100})
+
+	var f Fanout
+	f.Add(keptQ)
+	f.Add(removedQ)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	f.Send([]prometheus.Metric{testMetric(t, tempest.Rssi, -1, time.Now(), "ST-00019709")})
+	deadline := time.Now().Add(5 * time.Second)
+	for kept.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The removed queue is drained before Replace returns, even though its batch isn't due
+	f.Replace([]*Queue{keptQ, NewQueue("added", added, Filter{}, QueueOptions{})})
+	if removed.count() != 1 {
+		t.Errorf("removed sink was sent %d metrics, want 1", removed.count())
+	}
+
+	f.Send([]prometheus.Metric{testMetric(t, tempest.Rssi, -2, time.Now(), "ST-00019709")})
+	deadline = time.Now().Add(5 * time.Second)
+	for (kept.count() < 2 || added.count() < 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if kept.count() != 2 || added.count() != 1 || removed.count() != 1 {
+		t.Errorf("kept, added and removed sinks were sent %d, %d and %d metrics, want 2, 1 and 1", kept.count(), added.count(), removed.count())
+	}
+	if f.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", f.Len())
+	}
+}
+
+func TestFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "metrics.txt")
+	f, err := NewFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.UnixMilli(1688668741000)
+	if err := f.Send(context.Background(), []prometheus.Metric{testMetric(t, tempest.Rssi, -60, ts, "ST-00019709")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "tempest_rssi_dbm{instance=\"ST-00019709\"} -60 1688668741000\n"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	s := NewScrape("", time.Minute)
+	now := time.Now()
+	if err := s.Send(context.Background(), []prometheus.Metric{
+		testMetric(t, tempest.Rssi, -60, now, "ST-00019709"),
+		// An older report doesn't replace a newer one
+		testMetric(t, tempest.Rssi, -70, now.Add(-time.Minute), "ST-00019709"),
+		testMetric(t, tempest.Temperature, 19, now, "ST-00019709", "air"),
+		tempest.Flag(testMetric(t, tempest.Humidity, 104, now, "ST-00019709"), "range"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"tempest_rssi_dbm{instance=\"ST-00019709\"} -60\n",
+		"tempest_temperature_c{instance=\"ST-00019709\",kind=\"air\"} 19\n",
+		"tempest_humidity_percent{instance=\"ST-00019709\",quality=\"range\"} 104\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("response doesn't contain %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestQueue_Put(t *testing.T) {
+	r := &recorder{}
+	q := NewQueue("test", r, Filter{}, QueueOptions{Capacity: 1, BatchSize: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	// Far more than the queue can hold, none of which are dropped
+	for i := 0; i < 100; i++ {
+		if err := q.Put(context.Background(), []prometheus.Metric{testMetric(t, tempest.Rssi, float64(-i), time.Now(), "ST-00019709")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	<-done
+
+	if r.count() != 100 || q.Dropped() != 0 {
+		t.Errorf("sent %d metrics and dropped %d, want 100 and 0", r.count(), q.Dropped())
+	}
+
+	// Put gives up once its context is done
+	q = NewQueue("test", r, Filter{}, QueueOptions{Capacity: 1})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Put(ctx, []prometheus.Metric{
+		testMetric(t, tempest.Rssi, -1, time.Now(), "ST-00019709"),
+		testMetric(t, tempest.Rssi, -2, time.Now(), "ST-00019709"),
+	}); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSockopts prepares a socket before it is bound, sharing its port with other sockets if asked. Binding to an
+// interface is only supported on Linux.
+func setSockopts(fd uintptr, iface string, reusePort bool) error {
+	if iface != "" {
+		return errors.New("binding to an interface is only supported on Linux")
+	}
+	if reusePort {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return fmt.Errorf("setting SO_REUSEPORT: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSockopts prepares a socket before it is bound, optionally restricting it to one network interface and sharing
+// its port with other sockets.
+func setSockopts(fd uintptr, iface string, reusePort bool) error {
+	if iface != "" {
+		if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface); err != nil {
+			return fmt.Errorf("binding to interface %s: %w", iface, err)
+		}
+	}
+	if reusePort {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return fmt.Errorf("setting SO_REUSEPORT: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// setSockopts would prepare a socket before it is bound, but neither option is supported here.
+func setSockopts(fd uintptr, iface string, reusePort bool) error {
+	if iface != "" {
+		return errors.New("binding to an interface is only supported on Linux")
+	}
+	if reusePort {
+		return errors.New("reuse_port is not supported on this platform")
+	}
+	return nil
+}
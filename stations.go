@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"tempest_exporter/tempestapi"
+)
+
+func stations(ctx context.Context, configPath string, args []string) error {
+	fs, path := commandFlags("stations", configPath)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := noArgs(fs); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	if cfg.Token == "" {
+		return configError{errTokenRequired}
+	}
+
+	all, err := tempestapi.NewClient(cfg.Token).ListAllStations(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing stations: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATION\tNAME\tCREATED\tDEVICE\tTYPE\tSERIAL\tBACKFILL")
+	for _, s := range all {
+		hasTempest := false
+		for _, d := range s.Devices {
+			hasTempest = hasTempest || d.DeviceType == "ST"
+		}
+		if len(s.Devices) == 0 {
+			fmt.Fprintf(w, "%d\t%s\t%s\t\t\t\tskipped: no devices\n", s.StationID, s.Name, s.CreatedAt.Format(time.DateOnly))
+		}
+		for _, d := range s.Devices {
+			var status string
+			switch {
+			case d.DeviceType == "ST":
+				status = "yes"
+			case !hasTempest:
+				status = "skipped: station has no Tempest"
+			default:
+				status = "skipped: not a Tempest"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", s.StationID, s.Name, s.CreatedAt.Format(time.DateOnly), d.DeviceID, d.DeviceType, d.SerialNumber, status)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, s := range all {
+		for _, d := range s.Devices {
+			if d.DeviceType == "ST" {
+				if _, ok := cfg.Stations[d.SerialNumber]; !ok && len(cfg.Stations) > 0 {
+					fmt.Fprintf(os.Stderr, "note: %s is not described in the configuration file\n", d.SerialNumber)
+				}
+			}
+		}
+	}
+	return nil
+}
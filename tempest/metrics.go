@@ -1,6 +1,8 @@
 package tempest
 
 import (
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -22,28 +24,121 @@ var (
 	Pressure       *prometheus.Desc
 	Temperature    *prometheus.Desc // "air", "wetbulb"
 	Humidity       *prometheus.Desc
+
+	SeaLevelPressure *prometheus.Desc
+	StationInfo      *prometheus.Desc
+	ClockSkew        *prometheus.Desc
+
+	PowerSaveMode         *prometheus.Desc
+	BatteryTrend          *prometheus.Desc
+	BatteryTimeToCritical *prometheus.Desc
+)
+
+// Readings as they were before calibration, in families of their own with the same units and labels as the calibrated
+// readings
+var (
+	UncalibratedIlluminance *prometheus.Desc
+	UncalibratedUV          *prometheus.Desc
+	UncalibratedRainRate    *prometheus.Desc
+	UncalibratedWind        *prometheus.Desc
+	UncalibratedIrradiance  *prometheus.Desc
+	UncalibratedPressure    *prometheus.Desc
+	UncalibratedTemperature *prometheus.Desc
+	UncalibratedHumidity    *prometheus.Desc
+)
+
+// The exporter's own metrics
+var (
+	ConfigReloadSuccessful *prometheus.Desc
+	ConfigReloadTime       *prometheus.Desc
+	DuplicatesSuppressed   *prometheus.Desc
+	MessagesRejected       *prometheus.Desc
+	QCFailures             *prometheus.Desc
 )
 
 var All []*prometheus.Desc
 
+// Family describes a metric family in terms which can be used outside the Prometheus client library.
+type Family struct {
+	Desc *prometheus.Desc
+	Name string
+	Help string
+	Type prometheus.ValueType
+
+	// The unit in which values are expressed, which is also a suffix of Name, or "" if there is no suitable unit
+	Unit string
+
+	Labels []string
+}
+
+var families = make(map[*prometheus.Desc]*Family)
+
+func newDesc(name string, typ prometheus.ValueType, unit string, help string, labels []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labels, nil)
+	families[desc] = &Family{
+		Desc:   desc,
+		Name:   name,
+		Help:   help,
+		Type:   typ,
+		Unit:   unit,
+		Labels: labels,
+	}
+	return desc
+}
+
+// uncalibrated returns the family for readings of a calibrated family as they were before calibration.
+func uncalibrated(desc *prometheus.Desc) *prometheus.Desc {
+	f := families[desc]
+	return newDesc("tempest_uncalibrated_"+strings.TrimPrefix(f.Name, "tempest_"), f.Type, f.Unit, f.Help+", before calibration", f.Labels)
+}
+
+// Lookup returns the Family for a Desc defined by this package, or nil if it is unknown.
+func Lookup(desc *prometheus.Desc) *Family {
+	return families[desc]
+}
+
 func init() {
-	Uptime = prometheus.NewDesc("tempest_uptime_seconds_total", "The uptime of the device", []string{"instance"}, nil)
-	Rssi = prometheus.NewDesc("tempest_rssi_dbm", "A measurement of wireless signal strength", []string{"instance"}, nil)
-	Reboots = prometheus.NewDesc("tempest_reboots_total", "The number of times the device has rebooted", []string{"instance"}, nil)
-	BusErrors = prometheus.NewDesc("tempest_bus_errors_total", "The number of I2C bus errors experienced by the device", []string{"instance"}, nil)
-
-	Illuminance = prometheus.NewDesc("tempest_illuminance_lux", "A measurement of luminous flux per unit area", []string{"instance"}, nil)
-	UV = prometheus.NewDesc("tempest_uv_index", "A measurement of ultraviolet light intensity", []string{"instance"}, nil)
-	RainRate = prometheus.NewDesc("tempest_rain_rate_mm_min", "The amount of rain which fell on the sensor in the previous minute", []string{"instance"}, nil)
-	Wind = prometheus.NewDesc("tempest_wind_ms", "A wind speed measurement", []string{"instance", "kind"}, nil)
-	WindDirection = prometheus.NewDesc("tempest_wind_direction_degrees", "The direction from which the wind is blowing", []string{"instance"}, nil)
-	Battery = prometheus.NewDesc("tempest_battery_volts", "The electric potential of the battery", []string{"instance"}, nil)
-	ReportInterval = prometheus.NewDesc("tempest_report_interval_s", "The interval over with which the station makes reports", []string{"instance"}, nil)
-	Irradiance = prometheus.NewDesc("tempest_irradiance_w_m2", "The total solar irradiance, expressed in watts per square meter", []string{"instance"}, nil)
-	RainTotal = prometheus.NewDesc("tempest_rainfall_total", "The amount of accumulated rain", []string{"instance"}, nil)
-	Pressure = prometheus.NewDesc("tempest_pressure_pa", "A barometric pressure measurement", []string{"instance"}, nil)
-	Temperature = prometheus.NewDesc("tempest_temperature_c", "A temperature measurement", []string{"instance", "kind"}, nil)
-	Humidity = prometheus.NewDesc("tempest_humidity_percent", "A relative humidity measurement", []string{"instance"}, nil)
+	Uptime = newDesc("tempest_uptime_seconds_total", prometheus.CounterValue, "seconds", "The uptime of the device", []string{"instance"})
+	Rssi = newDesc("tempest_rssi_dbm", prometheus.GaugeValue, "dbm", "A measurement of wireless signal strength", []string{"instance"})
+	Reboots = newDesc("tempest_reboots_total", prometheus.CounterValue, "", "The number of times the device has rebooted", []string{"instance"})
+	BusErrors = newDesc("tempest_bus_errors_total", prometheus.CounterValue, "", "The number of I2C bus errors experienced by the device", []string{"instance"})
+
+	Illuminance = newDesc("tempest_illuminance_lux", prometheus.GaugeValue, "lux", "A measurement of luminous flux per unit area", []string{"instance"})
+	UV = newDesc("tempest_uv_index", prometheus.GaugeValue, "", "A measurement of ultraviolet light intensity", []string{"instance"})
+	RainRate = newDesc("tempest_rain_rate_mm_min", prometheus.GaugeValue, "mm_min", "The amount of rain which fell on the sensor in the previous minute", []string{"instance"})
+	Wind = newDesc("tempest_wind_ms", prometheus.GaugeValue, "ms", "A wind speed measurement", []string{"instance", "kind"})
+	WindDirection = newDesc("tempest_wind_direction_degrees", prometheus.GaugeValue, "degrees", "The direction from which the wind is blowing", []string{"instance"})
+	Battery = newDesc("tempest_battery_volts", prometheus.GaugeValue, "volts", "The electric potential of the battery", []string{"instance"})
+	ReportInterval = newDesc("tempest_report_interval_s", prometheus.GaugeValue, "s", "The interval over with which the station makes reports", []string{"instance"})
+	Irradiance = newDesc("tempest_irradiance_w_m2", prometheus.GaugeValue, "w_m2", "The total solar irradiance, expressed in watts per square meter", []string{"instance"})
+	RainTotal = newDesc("tempest_rainfall_total", prometheus.CounterValue, "", "The amount of accumulated rain", []string{"instance"})
+	Pressure = newDesc("tempest_pressure_pa", prometheus.GaugeValue, "pa", "A barometric pressure measurement", []string{"instance"})
+	Temperature = newDesc("tempest_temperature_c", prometheus.GaugeValue, "c", "A temperature measurement", []string{"instance", "kind"})
+	Humidity = newDesc("tempest_humidity_percent", prometheus.GaugeValue, "percent", "A relative humidity measurement", []string{"instance"})
+
+	SeaLevelPressure = newDesc("tempest_sea_level_pressure_pa", prometheus.GaugeValue, "pa", "The barometric pressure reduced to sea level using the station's elevation", []string{"instance"})
+	StationInfo = newDesc("tempest_station_info", prometheus.GaugeValue, "", "Always 1, labelled with the station's configured name", []string{"instance", "name"})
+
+	UncalibratedIlluminance = uncalibrated(Illuminance)
+	UncalibratedUV = uncalibrated(UV)
+	UncalibratedRainRate = uncalibrated(RainRate)
+	UncalibratedWind = uncalibrated(Wind)
+	UncalibratedIrradiance = uncalibrated(Irradiance)
+	UncalibratedPressure = uncalibrated(Pressure)
+	UncalibratedTemperature = uncalibrated(Temperature)
+	UncalibratedHumidity = uncalibrated(Humidity)
+
+	ClockSkew = newDesc("tempest_clock_skew_seconds", prometheus.GaugeValue, "seconds", "How far behind the exporter's clock the device's was when it last reported, or negative if ahead", []string{"instance"})
+
+	PowerSaveMode = newDesc("tempest_power_save_mode", prometheus.GaugeValue, "", "The power save mode the device has likely entered, from 0 for full performance to 3 for reports only every five minutes", []string{"instance"})
+	BatteryTrend = newDesc("tempest_battery_trend", prometheus.GaugeValue, "", "1 if the battery has been charging over the last few hours, -1 if discharging, or 0 if steady", []string{"instance"})
+	BatteryTimeToCritical = newDesc("tempest_battery_time_to_critical_seconds", prometheus.GaugeValue, "seconds", "How long until the battery falls to the voltage of the last power save mode at its recent rate of discharge", []string{"instance"})
+
+	ConfigReloadSuccessful = newDesc("tempest_exporter_config_last_reload_successful", prometheus.GaugeValue, "", "Whether the last attempt to reload the configuration succeeded", nil)
+	ConfigReloadTime = newDesc("tempest_exporter_config_last_reload_success_timestamp_seconds", prometheus.GaugeValue, "seconds", "When the configuration was last loaded successfully", nil)
+	DuplicatesSuppressed = newDesc("tempest_exporter_duplicates_suppressed_total", prometheus.CounterValue, "", "The number of messages dropped for having been received already", nil)
+	MessagesRejected = newDesc("tempest_exporter_messages_rejected_total", prometheus.CounterValue, "", "The number of messages rejected by the source rules, by the kind of rule", []string{"reason"})
+	QCFailures = newDesc("tempest_exporter_qc_failures_total", prometheus.CounterValue, "", "The number of readings which failed a quality check, by the kind of reading and the check", []string{"kind", "check"})
 
 	// todo: lightning
 
@@ -65,5 +160,26 @@ func init() {
 		Pressure,
 		Temperature,
 		Humidity,
+
+		SeaLevelPressure,
+		StationInfo,
+		UncalibratedIlluminance,
+		UncalibratedUV,
+		UncalibratedRainRate,
+		UncalibratedWind,
+		UncalibratedIrradiance,
+		UncalibratedPressure,
+		UncalibratedTemperature,
+		UncalibratedHumidity,
+		ClockSkew,
+		PowerSaveMode,
+		BatteryTrend,
+		BatteryTimeToCritical,
+
+		ConfigReloadSuccessful,
+		ConfigReloadTime,
+		DuplicatesSuppressed,
+		MessagesRejected,
+		QCFailures,
 	}
 }
@@ -0,0 +1,66 @@
+package tempest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OpenMetricsName returns the name of the family in OpenMetrics terms, which for counters excludes the "_total" suffix
+// carried by each sample.
+func (f *Family) OpenMetricsName() string {
+	if f.Type == prometheus.CounterValue {
+		return strings.TrimSuffix(f.Name, "_total")
+	}
+	return f.Name
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// AppendOpenMetricsHeader appends the TYPE, UNIT, and HELP lines which describe the family in OpenMetrics text format.
+func (f *Family) AppendOpenMetricsHeader(b []byte) []byte {
+	name := f.OpenMetricsName()
+
+	b = append(b, "# TYPE "...)
+	b = append(b, name...)
+	switch f.Type {
+	case prometheus.CounterValue:
+		b = append(b, " counter\n"...)
+	case prometheus.GaugeValue:
+		b = append(b, " gauge\n"...)
+	default:
+		b = append(b, " unknown\n"...)
+	}
+
+	if f.Unit != "" {
+		b = append(b, "# UNIT "...)
+		b = append(b, name...)
+		b = append(b, ' ')
+		b = append(b, f.Unit...)
+		b = append(b, '\n')
+	}
+
+	b = append(b, "# HELP "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = append(b, helpEscaper.Replace(f.Help)...)
+	return append(b, '\n')
+}
+
+// AppendOpenMetrics appends the sample to b as a line of OpenMetrics text format.
+func (s Sample) AppendOpenMetrics(b []byte) []byte {
+	b = append(b, s.Family.OpenMetricsName()...)
+	if s.Family.Type == prometheus.CounterValue {
+		b = append(b, "_total"...)
+	}
+	b = appendLabels(b, s.Labels)
+	b = append(b, ' ')
+	b = appendFloat(b, s.Value)
+	if s.TimestampMs != 0 {
+		// OpenMetrics timestamps are in seconds
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, float64(s.TimestampMs)/1000, 'f', -1, 64)
+	}
+	return append(b, '\n')
+}
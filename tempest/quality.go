@@ -0,0 +1,32 @@
+package tempest
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// QualityLabel marks a reading which failed a quality check, naming the check. Readings which passed don't have it.
+const QualityLabel = "quality"
+
+// Flag returns m with QualityLabel set to the check it failed. The label isn't part of the family's Desc, so flagged
+// metrics can only be gathered by an unchecked collector.
+func Flag(m prometheus.Metric, check string) prometheus.Metric {
+	return flagged{Metric: m, check: check}
+}
+
+type flagged struct {
+	prometheus.Metric
+	check string
+}
+
+func (f flagged) Write(out *dto.Metric) error {
+	if err := f.Metric.Write(out); err != nil {
+		return err
+	}
+	name, value := QualityLabel, f.check
+	out.Label = append(out.Label, &dto.LabelPair{Name: &name, Value: &value})
+	sort.Slice(out.Label, func(i, j int) bool { return out.Label[i].GetName() < out.Label[j].GetName() })
+	return nil
+}
@@ -0,0 +1,117 @@
+package tempest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Sample is a single timestamped value from a metric, flattened into a form which is cheap to store and encode.
+type Sample struct {
+	Family      *Family
+	Labels      []Label // sorted by name
+	Value       float64
+	TimestampMs int64
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// NewSample flattens a metric produced using one of this package's Descs.
+func NewSample(m prometheus.Metric) (Sample, error) {
+	family := Lookup(m.Desc())
+	if family == nil {
+		return Sample{}, fmt.Errorf("unknown metric: %s", m.Desc())
+	}
+
+	var dm dto.Metric
+	if err := m.Write(&dm); err != nil {
+		return Sample{}, err
+	}
+
+	s := Sample{
+		Family:      family,
+		Labels:      make([]Label, 0, len(dm.GetLabel())),
+		TimestampMs: dm.GetTimestampMs(),
+	}
+	for _, label := range dm.GetLabel() {
+		s.Labels = append(s.Labels, Label{Name: label.GetName(), Value: label.GetValue()})
+	}
+	switch {
+	case dm.Counter != nil:
+		s.Value = dm.Counter.GetValue()
+	case dm.Gauge != nil:
+		s.Value = dm.Gauge.GetValue()
+	case dm.Untyped != nil:
+		s.Value = dm.Untyped.GetValue()
+	default:
+		return Sample{}, fmt.Errorf("unsupported metric type: %s", m.Desc())
+	}
+	return s, nil
+}
+
+// Label returns the value of the named label, or "" if it is not present.
+func (s Sample) Label(name string) string {
+	for _, label := range s.Labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// AppendSeries appends the name and labels which identify the sample's series, as written in text exposition format.
+func (s Sample) AppendSeries(b []byte) []byte {
+	b = append(b, s.Family.Name...)
+	return appendLabels(b, s.Labels)
+}
+
+// AppendText appends the sample to b as a line of Prometheus text exposition format.
+func (s Sample) AppendText(b []byte) []byte {
+	b = s.AppendSeries(b)
+	b = append(b, ' ')
+	b = appendFloat(b, s.Value)
+	if s.TimestampMs != 0 {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, s.TimestampMs, 10)
+	}
+	return append(b, '\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func appendLabels(b []byte, labels []Label) []byte {
+	if len(labels) == 0 {
+		return b
+	}
+	b = append(b, '{')
+	for i, label := range labels {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, label.Name...)
+		b = append(b, `="`...)
+		b = append(b, labelEscaper.Replace(label.Value)...)
+		b = append(b, '"')
+	}
+	return append(b, '}')
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(b, "+Inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-Inf"...)
+	case math.IsNaN(f):
+		return append(b, "NaN"...)
+	default:
+		return strconv.AppendFloat(b, f, 'g', -1, 64)
+	}
+}
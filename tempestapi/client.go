@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"tempest_exporter/tempestudp"
@@ -15,11 +16,35 @@ import (
 )
 
 type Client struct {
-	token string
+	token     string
+	endpoints Endpoints
+}
+
+// Endpoints locates the WeatherFlow APIs.
+type Endpoints struct {
+	REST      string
+	WebSocket string
+}
+
+var DefaultEndpoints = Endpoints{
+	REST:      "https://swd.weatherflow.com/swd/rest",
+	WebSocket: "wss://ws.weatherflow.com/swd/data",
 }
 
 func NewClient(token string) Client {
-	return Client{token: token}
+	return Client{token: token, endpoints: DefaultEndpoints}
+}
+
+// NewClientWithEndpoints returns a client for APIs elsewhere, such as a stand-in for testing. Empty endpoints are
+// defaulted.
+func NewClientWithEndpoints(token string, endpoints Endpoints) Client {
+	if endpoints.REST == "" {
+		endpoints.REST = DefaultEndpoints.REST
+	}
+	if endpoints.WebSocket == "" {
+		endpoints.WebSocket = DefaultEndpoints.WebSocket
+	}
+	return Client{token: token, endpoints: endpoints}
 }
 
 type Station struct {
@@ -28,10 +53,38 @@ type Station struct {
 	deviceID     int
 	serialNumber string
 	CreatedAt    time.Time
+
+	// Every device at the station, including hubs and older sensors which we don't fetch history for
+	Devices []Device
+}
+
+type Device struct {
+	DeviceID int
+
+	// "ST" for a Tempest, "HB" for a hub, "AR" for an AIR, or "SK" for a SKY
+	DeviceType   string
+	SerialNumber string
 }
 
+// ListStations lists the stations which have a Tempest, which are the ones we can fetch history for.
 func (c Client) ListStations(ctx context.Context) ([]Station, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://swd.weatherflow.com/swd/rest/stations?token="+c.token, nil)
+	all, err := c.ListAllStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Station
+	for _, station := range all {
+		if station.deviceID != 0 && station.serialNumber != "" {
+			out = append(out, station)
+		}
+	}
+	return out, nil
+}
+
+// ListAllStations lists every station on the account, whether or not it has a Tempest.
+func (c Client) ListAllStations(ctx context.Context) ([]Station, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoints.REST+"/stations?token="+url.QueryEscape(c.token), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -64,31 +117,36 @@ func (c Client) ListStations(ctx context.Context) ([]Station, error) {
 
 	var out []Station
 	for _, station := range data.Stations {
-		var deviceId int
-		var instance string
+		s := Station{
+			Name:      station.Name,
+			StationID: station.StationID,
+			CreatedAt: time.Unix(station.CreatedEpoch, 0),
+		}
 		for _, dev := range station.Devices {
+			s.Devices = append(s.Devices, Device{
+				DeviceID:     dev.DeviceID,
+				DeviceType:   dev.DeviceType,
+				SerialNumber: dev.SerialNumber,
+			})
 			if dev.DeviceType == "ST" {
-				deviceId = dev.DeviceID
-				instance = dev.SerialNumber
+				s.deviceID = dev.DeviceID
+				s.serialNumber = dev.SerialNumber
 			}
 		}
-
-		if deviceId != 0 && instance != "" {
-			out = append(out, Station{
-				Name:         station.Name,
-				deviceID:     deviceId,
-				serialNumber: instance,
-				StationID:    station.StationID,
-				CreatedAt:    time.Unix(station.CreatedEpoch, 0),
-			})
-		}
+		out = append(out, s)
 	}
 	return out, nil
 }
 
 func (c Client) GetObservations(ctx context.Context, station Station, startAt time.Time, endAt time.Time) ([]prometheus.Metric, error) {
-	url := fmt.Sprintf("https://swd.weatherflow.com/swd/rest/observations/device/%d?token=%s&time_start=%d&time_end=%d", station.deviceID, c.token, startAt.Unix(), endAt.Unix())
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return c.GetObservationsWithOptions(ctx, station, startAt, endAt, nil)
+}
+
+// GetObservationsWithOptions is like GetObservations, using options, if not nil, to look up the tempestudp.Options for
+// the station's device, as for reports received live.
+func (c Client) GetObservationsWithOptions(ctx context.Context, station Station, startAt time.Time, endAt time.Time, options func(serial string) tempestudp.Options) ([]prometheus.Metric, error) {
+	u := fmt.Sprintf("%s/observations/device/%d?token=%s&time_start=%d&time_end=%d", c.endpoints.REST, station.deviceID, url.QueryEscape(c.token), startAt.Unix(), endAt.Unix())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +170,9 @@ func (c Client) GetObservations(ctx context.Context, station Station, startAt ti
 	switch r := report.(type) {
 	case *tempestudp.TempestObservationReport:
 		r.SerialNumber = station.serialNumber
+		if options != nil {
+			r.Options = options(r.SerialNumber)
+		}
 	default:
 		log.Fatalf("unhandled report type")
 	}
@@ -0,0 +1,60 @@
+package tempestapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tempest_exporter/tempest"
+	"tempest_exporter/tempestudp"
+)
+
+func TestClient_GetObservationsWithOptions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/stations", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(stations))
+	})
+	mux.HandleFunc("/rest/observations/device/101", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":{"status_code":0,"status_message":"SUCCESS"},"device_id":101,"type":"obs_st","source":"db",
+			"obs":[[1688668741,0.18,0.63,1.29,105,3,1014.72,19.5,71,0,0,0,0,0,0,0,2.63,1]]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewClientWithEndpoints("s3cret", Endpoints{REST: srv.URL + "/rest"})
+	stations, err := c.ListStations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serial string
+	metrics, err := c.GetObservationsWithOptions(context.Background(), stations[0], time.Unix(1688668572, 0), time.Unix(1688754972, 0), func(s string) tempestudp.Options {
+		serial = s
+		return tempestudp.Options{
+			Name:        "Back garden",
+			Calibration: tempestudp.Calibration{Temperature: tempestudp.Adjustment{Offset: -0.8}},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "ST-00019709" {
+		t.Errorf("options looked up for %q, want ST-00019709", serial)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range metrics {
+		s, err := tempest.NewSample(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[s.Family.Name+"/"+s.Label("kind")] = s.Value
+	}
+	if v := got["tempest_temperature_c/air"]; v != 18.7 {
+		t.Errorf("air temperature %v, want 18.7 after calibration", v)
+	}
+	if _, ok := got["tempest_station_info/"]; !ok {
+		t.Error("no tempest_station_info")
+	}
+}
@@ -0,0 +1,164 @@
+package tempestapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Delays between WebSocket reconnection attempts
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// How long the WebSocket may go without a message, given that rapid wind arrives every few seconds, before we give
+// up on it and reconnect
+const readTimeout = 2 * time.Minute
+
+// Listen receives live observations and events over the WebSocket API for every device at the stations returned by
+// ListStations, calling fn with each in the same form as a UDP broadcast. The station list is fetched again on each
+// connection. If the connection fails, Listen reconnects with increasing delays. It returns once ctx is done.
+func (c Client) Listen(ctx context.Context, fn func([]byte) error) error {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := c.listen(ctx, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// A connection which lasted a while deserves a quick retry
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Printf("WebSocket: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// listen makes one connection, returning once it fails or ctx is done.
+func (c Client) listen(ctx context.Context, fn func([]byte) error) error {
+	stations, err := c.ListStations(ctx)
+	if err != nil {
+		return fmt.Errorf("listing stations: %w", err)
+	}
+	// Hubs send nothing over the WebSocket, but we name them in messages from their devices as broadcasts do
+	devices := make(map[int]device)
+	for _, station := range stations {
+		var hub string
+		for _, dev := range station.Devices {
+			if dev.DeviceType == "HB" {
+				hub = dev.SerialNumber
+			}
+		}
+		for _, dev := range station.Devices {
+			if dev.DeviceType != "HB" {
+				devices[dev.DeviceID] = device{serial: dev.SerialNumber, hub: hub}
+			}
+		}
+	}
+	if len(devices) == 0 {
+		return errors.New("no devices to listen to")
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.endpoints.WebSocket+"?token="+url.QueryEscape(c.token), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock reads once we're done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for id := range devices {
+		for _, typ := range []string{"listen_start", "listen_rapid_start"} {
+			req := map[string]interface{}{"type": typ, "device_id": id, "id": typ + "-" + strconv.Itoa(id)}
+			if err := conn.WriteJSON(req); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("WebSocket: listening to %d devices", len(devices))
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		msg, err := asBroadcast(b, devices)
+		if err != nil {
+			log.Printf("WebSocket: %v: %s", err, b)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// device identifies a device in the messages from it.
+type device struct {
+	serial string
+	hub    string
+}
+
+// asBroadcast converts a WebSocket message to the form of a UDP broadcast, filling in the device's serial number and
+// hub where they are missing. It returns nil for messages about the connection itself.
+func asBroadcast(b []byte, devices map[int]device) ([]byte, error) {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	var typ string
+	json.Unmarshal(msg["type"], &typ)
+	switch typ {
+	case "obs_st", "rapid_wind", "evt_precip", "evt_strike":
+	case "connection_opened", "ack":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected message type %q", typ)
+	}
+
+	var id int
+	json.Unmarshal(msg["device_id"], &id)
+	dev, ok := devices[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown device %d", id)
+	}
+	if _, ok := msg["serial_number"]; !ok {
+		msg["serial_number"], _ = json.Marshal(dev.serial)
+	}
+	if _, ok := msg["hub_sn"]; !ok && dev.hub != "" {
+		msg["hub_sn"], _ = json.Marshal(dev.hub)
+	}
+	return json.Marshal(msg)
+}
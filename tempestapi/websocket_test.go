@@ -0,0 +1,108 @@
+package tempestapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const stations = `{"stations":[{"name":"Home","station_id":1234,"created_epoch":1688668572,"devices":[
+	{"device_id":100,"device_type":"HB","serial_number":"HB-00031344"},
+	{"device_id":101,"device_type":"ST","serial_number":"ST-00019709"}]}],
+	"status":{"status_code":0,"status_message":"SUCCESS"}}`
+
+func TestClient_Listen(t *testing.T) {
+	// Each connection reports the requests it received, then sends an observation and hangs up
+	requests := make(chan []string, 2)
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/stations", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(stations))
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_opened"}`))
+
+		var got []string
+		for i := 0; i < 2; i++ {
+			var req struct {
+				Type     string `json:"type"`
+				DeviceID int    `json:"device_id"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.DeviceID != 101 {
+				t.Errorf("%s for device %d, want 101", req.Type, req.DeviceID)
+			}
+			got = append(got, req.Type)
+		}
+		requests <- got
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ack","id":"listen_start-101"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"rapid_wind","device_id":101,"ob":[1688668572,0.85,113]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewClientWithEndpoints("s3cret", Endpoints{
+		REST:      srv.URL + "/rest",
+		WebSocket: "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+	})
+	received := make(chan []byte, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Listen(ctx, func(b []byte) error {
+			received <- b
+			return nil
+		})
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Once to begin with, and again after reconnecting
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-requests:
+			if strings.Join(got, ",") != "listen_start,listen_rapid_start" {
+				t.Errorf("requests = %v", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("made %d connections, want 2", i)
+		}
+
+		select {
+		case b := <-received:
+			var msg struct {
+				SerialNumber string `json:"serial_number"`
+				Type         string `json:"type"`
+				HubSn        string `json:"hub_sn"`
+			}
+			if err := json.Unmarshal(b, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.SerialNumber != "ST-00019709" || msg.Type != "rapid_wind" || msg.HubSn != "HB-00031344" {
+				t.Errorf("received %s", b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("received nothing")
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tempestudp
+
+import (
+	"math"
+
+	"tempest_exporter/tempest"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options adjusts how a station's reports are turned into metrics.
+type Options struct {
+	// The station's name, which if set is exported as tempest_station_info
+	Name string
+
+	// The station's elevation in metres above sea level, used to derive sea level pressure
+	Elevation float64
+
+	// Adjustments applied to raw readings before anything is derived from them
+	Calibration Calibration
+
+	// Whether to also export the readings which are calibrated as they were before calibration
+	Uncalibrated bool
+
+	// Whether to leave out wet bulb temperature
+	SkipWetBulb bool
+
+	// Whether to derive sea level pressure from the station's elevation
+	SeaLevelPressure bool
+}
+
+// Calibration holds an adjustment for each kind of reading.
+type Calibration struct {
+	Temperature Adjustment
+	Humidity    Adjustment
+	Pressure    Adjustment
+	Wind        Adjustment
+	Illuminance Adjustment
+	UV          Adjustment
+	Irradiance  Adjustment
+	Rain        Adjustment
+}
+
+// Adjustment corrects a reading by interpolating between the points of a linear correction, if any, then scaling it,
+// then adding an offset. A zero Scale is treated as 1, so the zero Adjustment leaves readings unchanged.
+type Adjustment struct {
+	Offset float64
+	Scale  float64
+
+	// Readings and their corrected values, in increasing order of reading. Readings between two points are corrected
+	// by interpolating between them, and readings beyond the first or last by extending the nearest line.
+	Linear []Point
+}
+
+// Point pairs a reading with its correct value, such as from a reference instrument.
+type Point struct {
+	Reading   float64
+	Corrected float64
+}
+
+func (a Adjustment) apply(v float64) float64 {
+	if len(a.Linear) >= 2 {
+		v = interpolate(a.Linear, v)
+	}
+	if a.Scale != 0 {
+		v *= a.Scale
+	}
+	return v + a.Offset
+}
+
+func (a Adjustment) isZero() bool {
+	return a.Offset == 0 && (a.Scale == 0 || a.Scale == 1) && len(a.Linear) < 2
+}
+
+// interpolate corrects v using the line between the two points which surround it, or the nearest two.
+func interpolate(points []Point, v float64) float64 {
+	i := 1
+	for i < len(points)-1 && v > points[i].Reading {
+		i++
+	}
+	p, q := points[i-1], points[i]
+	return p.Corrected + (v-p.Reading)*(q.Corrected-p.Corrected)/(q.Reading-p.Reading)
+}
+
+// calibrated describes a reading in an obs_st observation which can be calibrated.
+type calibrated struct {
+	index int
+
+	// The family and kind label, if any, of the reading before calibration, and the factor to convert it to the same
+	// units as its calibrated metric
+	desc   *prometheus.Desc
+	kind   string
+	factor float64
+
+	adjustment func(Calibration) Adjustment
+}
+
+var obsCalibrated = []calibrated{
+	{1, tempest.UncalibratedWind, "lull", 1, func(c Calibration) Adjustment { return c.Wind }},
+	{2, tempest.UncalibratedWind, "avg", 1, func(c Calibration) Adjustment { return c.Wind }},
+	{3, tempest.UncalibratedWind, "gust", 1, func(c Calibration) Adjustment { return c.Wind }},
+	{6, tempest.UncalibratedPressure, "", 100, func(c Calibration) Adjustment { return c.Pressure }},
+	{7, tempest.UncalibratedTemperature, "air", 1, func(c Calibration) Adjustment { return c.Temperature }},
+	{8, tempest.UncalibratedHumidity, "", 1, func(c Calibration) Adjustment { return c.Humidity }},
+	{9, tempest.UncalibratedIlluminance, "", 1, func(c Calibration) Adjustment { return c.Illuminance }},
+	{10, tempest.UncalibratedUV, "", 1, func(c Calibration) Adjustment { return c.UV }},
+	{11, tempest.UncalibratedIrradiance, "", 1, func(c Calibration) Adjustment { return c.Irradiance }},
+	{12, tempest.UncalibratedRainRate, "", 1, func(c Calibration) Adjustment { return c.Rain }},
+}
+
+// applyObs returns a calibrated copy of an obs_st observation.
+func (c Calibration) applyObs(ob []float64) []float64 {
+	var out []float64
+	for _, r := range obsCalibrated {
+		a := r.adjustment(c)
+		if a.isZero() {
+			continue
+		}
+		if out == nil {
+			out = append([]float64(nil), ob...)
+		}
+		out[r.index] = a.apply(out[r.index])
+	}
+	if out == nil {
+		return ob
+	}
+	return out
+}
+
+// uncalibratedObs returns the readings in an obs_st observation which calibration changes, as they were before.
+func (c Calibration) uncalibratedObs(ob []float64, serial string) []prometheus.Metric {
+	var out []prometheus.Metric
+	for _, r := range obsCalibrated {
+		if r.adjustment(c).isZero() {
+			continue
+		}
+		labels := []string{serial}
+		if r.kind != "" {
+			labels = append(labels, r.kind)
+		}
+		out = append(out, prometheus.MustNewConstMetric(r.desc, prometheus.GaugeValue, ob[r.index]*r.factor, labels...))
+	}
+	return out
+}
+
+// seaLevelPressureHpa reduces station pressure to sea level using the hypsometric equation.
+func seaLevelPressureHpa(stationPressureHpa float64, temperatureC float64, elevationM float64) float64 {
+	return stationPressureHpa * math.Pow(1-0.0065*elevationM/(temperatureC+0.0065*elevationM+273.15), -5.257)
+}
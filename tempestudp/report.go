@@ -17,6 +17,11 @@ type Report interface {
 }
 
 func ParseReport(bytes []byte) (Report, error) {
+	return ParseReportWithOptions(bytes, nil)
+}
+
+// ParseReportWithOptions parses a report, using options, if not nil, to look up the Options for the reporting device.
+func ParseReportWithOptions(bytes []byte, options func(serial string) Options) (Report, error) {
 	var typ struct {
 		Type string `json:"type"`
 	}
@@ -44,9 +49,17 @@ func ParseReport(bytes []byte) (Report, error) {
 
 	if err := json.Unmarshal(bytes, data); err != nil {
 		return nil, err
-	} else {
-		return data, nil
 	}
+
+	if options != nil {
+		switch r := data.(type) {
+		case *TempestObservationReport:
+			r.Options = options(r.SerialNumber)
+		case *rapidWindReport:
+			r.options = options(r.SerialNumber)
+		}
+	}
+	return data, nil
 }
 
 type rainStartReport struct {
@@ -91,6 +104,8 @@ type rapidWindReport struct {
 
 	HubSn string    `json:"hub_sn"`
 	Ob    []float64 `json:"ob"`
+
+	options Options
 }
 
 func (r rapidWindReport) Metrics() []prometheus.Metric {
@@ -99,10 +114,16 @@ func (r rapidWindReport) Metrics() []prometheus.Metric {
 	}
 
 	ts := int64(r.Ob[0])
-	return withTime(ts, []prometheus.Metric{
-		prometheus.MustNewConstMetric(tempest.Wind, prometheus.GaugeValue, r.Ob[1], r.SerialNumber, "rapid"),
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(tempest.Wind, prometheus.GaugeValue, r.options.Calibration.Wind.apply(r.Ob[1]), r.SerialNumber, "rapid"),
 		prometheus.MustNewConstMetric(tempest.WindDirection, prometheus.GaugeValue, r.Ob[2], r.SerialNumber),
-	})
+	}
+	if r.options.Uncalibrated && !r.options.Calibration.Wind.isZero() {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(tempest.UncalibratedWind, prometheus.GaugeValue, r.Ob[1], r.SerialNumber, "rapid"),
+		)
+	}
+	return withTime(ts, metrics)
 }
 
 type TempestObservationReport struct {
@@ -134,6 +155,8 @@ type TempestObservationReport struct {
 	Obs [][]float64 `json:"obs"`
 
 	FirmwareRevision int `json:"firmware_revision"`
+
+	Options Options `json:"-"`
 }
 
 func (r TempestObservationReport) Metrics() []prometheus.Metric {
@@ -143,8 +166,8 @@ func (r TempestObservationReport) Metrics() []prometheus.Metric {
 		if len(ob) < 13 {
 			continue
 		}
-
-		wetBulb := wetBulbTemperatureC(ob[7], ob[8], ob[6])
+		raw := ob
+		ob = r.Options.Calibration.applyObs(ob)
 
 		metrics := []prometheus.Metric{
 			prometheus.MustNewConstMetric(tempest.Wind, prometheus.GaugeValue, ob[1], r.SerialNumber, "lull"),
@@ -153,12 +176,31 @@ func (r TempestObservationReport) Metrics() []prometheus.Metric {
 			prometheus.MustNewConstMetric(tempest.WindDirection, prometheus.GaugeValue, ob[4], r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.Pressure, prometheus.GaugeValue, ob[6]*100, r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, ob[7], r.SerialNumber, "air"),
-			prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, wetBulb, r.SerialNumber, "wetbulb"),
+		}
+		if !r.Options.SkipWetBulb {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(tempest.Temperature, prometheus.GaugeValue, wetBulbTemperatureC(ob[7], ob[8], ob[6]), r.SerialNumber, "wetbulb"),
+			)
+		}
+		metrics = append(metrics,
 			prometheus.MustNewConstMetric(tempest.Humidity, prometheus.GaugeValue, ob[8], r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.Illuminance, prometheus.GaugeValue, ob[9], r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.UV, prometheus.GaugeValue, ob[10], r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.Irradiance, prometheus.GaugeValue, ob[11], r.SerialNumber),
 			prometheus.MustNewConstMetric(tempest.RainRate, prometheus.GaugeValue, ob[12], r.SerialNumber),
+		)
+		if r.Options.SeaLevelPressure {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(tempest.SeaLevelPressure, prometheus.GaugeValue, seaLevelPressureHpa(ob[6], ob[7], r.Options.Elevation)*100, r.SerialNumber),
+			)
+		}
+		if r.Options.Name != "" {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(tempest.StationInfo, prometheus.GaugeValue, 1, r.SerialNumber, r.Options.Name),
+			)
+		}
+		if r.Options.Uncalibrated {
+			metrics = append(metrics, r.Options.Calibration.uncalibratedObs(raw, r.SerialNumber)...)
 		}
 		// todo: lightning
 		if len(ob) >= 17 {
@@ -249,12 +291,18 @@ type hubStatusReport struct {
 }
 
 func (r hubStatusReport) Metrics() []prometheus.Metric {
-	return withTime(r.Timestamp, []prometheus.Metric{
+	out := []prometheus.Metric{
 		prometheus.MustNewConstMetric(tempest.Uptime, prometheus.CounterValue, r.Uptime, r.SerialNumber),
 		prometheus.MustNewConstMetric(tempest.Rssi, prometheus.GaugeValue, r.Rssi, r.SerialNumber),
-		prometheus.MustNewConstMetric(tempest.Reboots, prometheus.CounterValue, r.RadioStats[1], r.SerialNumber),
-		prometheus.MustNewConstMetric(tempest.BusErrors, prometheus.CounterValue, r.RadioStats[2], r.SerialNumber),
-	})
+	}
+	// Older firmware doesn't always send radio_stats
+	if len(r.RadioStats) >= 3 {
+		out = append(out,
+			prometheus.MustNewConstMetric(tempest.Reboots, prometheus.CounterValue, r.RadioStats[1], r.SerialNumber),
+			prometheus.MustNewConstMetric(tempest.BusErrors, prometheus.CounterValue, r.RadioStats[2], r.SerialNumber),
+		)
+	}
+	return withTime(r.Timestamp, out)
 }
 
 func withTime(unix int64, metrics []prometheus.Metric) []prometheus.Metric {
@@ -131,6 +131,21 @@ func Test_hubStatusReport_Metrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			"without radio stats",
+			`{"serial_number":"HB-00031344","type":"hub_status","firmware_revision":"171","uptime":64275,"rssi":-44,"timestamp":1688666650,"reset_flags":"BOR,PIN,POR","seq":6419,"fs":[1,0,15675411,524288]}`,
+			"HB-00031344", 1688666650,
+			[]simpleMetric{
+				{
+					desc:  tempest.Uptime,
+					value: 64275,
+				},
+				{
+					desc:  tempest.Rssi,
+					value: -44,
+				},
+			},
+		},
 	})
 }
 
@@ -302,3 +317,123 @@ func TestParseReport(t *testing.T) {
 		})
 	}
 }
+
+func TestParseReportWithOptions(t *testing.T) {
+	input := `{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.00,0.49,1.44,163,3,987.81,19.00,67.63,57687,4.38,480,0.000000,0,0,0,2.792,1]],"firmware_revision":156}`
+	report, err := ParseReportWithOptions([]byte(input), func(serial string) Options {
+		if serial != "ST-00019709" {
+			t.Errorf("options requested for %q", serial)
+		}
+		return Options{
+			Name:      "Back garden",
+			Elevation: 120,
+			Calibration: Calibration{
+				Temperature: Adjustment{Offset: -0.8},
+				Wind:        Adjustment{Scale: 2},
+				Humidity:    Adjustment{Linear: []Point{{0, 0}, {60, 70}, {100, 100}}},
+			},
+			Uncalibrated:     true,
+			SkipWetBulb:      true,
+			SeaLevelPressure: true,
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range report.Metrics() {
+		var dm io_prometheus_client.Metric
+		if err := m.Write(&dm); err != nil {
+			t.Fatal(err)
+		}
+		key := tempest.Lookup(m.Desc()).Name
+		for _, label := range dm.GetLabel() {
+			if label.GetName() != "instance" {
+				key += "/" + label.GetValue()
+			}
+		}
+		got[key] = dm.GetGauge().GetValue()
+	}
+
+	for key, want := range map[string]float64{
+		"tempest_temperature_c/air":        18.2,
+		"tempest_wind_ms/avg":              0.98,
+		"tempest_pressure_pa":              98781,
+		"tempest_sea_level_pressure_pa":    seaLevelPressureHpa(987.81, 18.2, 120) * 100,
+		"tempest_station_info/Back garden": 1,
+		"tempest_humidity_percent":         75.7225,
+		"tempest_wind_direction_degrees":   163,
+
+		"tempest_uncalibrated_temperature_c/air": 19,
+		"tempest_uncalibrated_wind_ms/avg":       0.49,
+		"tempest_uncalibrated_humidity_percent":  67.63,
+	} {
+		if v, ok := got[key]; !ok || math.Abs(v-want) > 1e-6 {
+			t.Errorf("%s = %v, want %v", key, v, want)
+		}
+	}
+	if _, ok := got["tempest_temperature_c/wetbulb"]; ok {
+		t.Error("wet bulb temperature was not skipped")
+	}
+	if _, ok := got["tempest_uncalibrated_pressure_pa"]; ok {
+		t.Error("pressure was exported uncalibrated, though it isn't calibrated")
+	}
+}
+
+func TestParseReportWithOptions_wetBulb(t *testing.T) {
+	input := `{"serial_number":"ST-00019709","type":"obs_st","hub_sn":"HB-00031344","obs":[[1688668741,0.00,0.49,1.44,163,3,987.81,19.00,67.63,57687,4.38,480,0.000000,0,0,0,2.792,1]],"firmware_revision":156}`
+	report, err := ParseReportWithOptions([]byte(input), func(string) Options {
+		return Options{Calibration: Calibration{
+			Temperature: Adjustment{Offset: -0.8},
+			Humidity:    Adjustment{Scale: 1.1},
+		}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got float64
+	for _, m := range report.Metrics() {
+		var dm io_prometheus_client.Metric
+		if err := m.Write(&dm); err != nil {
+			t.Fatal(err)
+		}
+		if m.Desc() == tempest.Temperature && dm.GetLabel()[1].GetValue() == "wetbulb" {
+			got = dm.GetGauge().GetValue()
+		}
+	}
+
+	// Derived from the calibrated readings, not those received
+	want := wetBulbTemperatureC(18.2, 67.63*1.1, 987.81)
+	if math.Abs(got-want) > 1e-6 {
+		t.Errorf("wet bulb = %v, want %v, and %v before calibration", got, want, wetBulbTemperatureC(19, 67.63, 987.81))
+	}
+}
+
+func TestAdjustment_apply(t *testing.T) {
+	linear := []Point{{0, 1}, {10, 11}, {20, 19}}
+	tests := []struct {
+		name string
+		a    Adjustment
+		v    float64
+		want float64
+	}{
+		{"none", Adjustment{}, 12.5, 12.5},
+		{"offset", Adjustment{Offset: -0.8}, 20, 19.2},
+		{"scale", Adjustment{Scale: 1.15}, 2, 2.3},
+		{"scale then offset", Adjustment{Offset: 1, Scale: 2}, 3, 7},
+		{"linear", Adjustment{Linear: linear}, 5, 6},
+		{"linear second segment", Adjustment{Linear: linear}, 15, 15},
+		{"linear below", Adjustment{Linear: linear}, -10, -9},
+		{"linear above", Adjustment{Linear: linear}, 30, 27},
+		{"linear then scale", Adjustment{Linear: linear, Scale: 2}, 5, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.apply(tt.v); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("apply(%v) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}